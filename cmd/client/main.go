@@ -2,12 +2,20 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/jessevdk/go-flags"
+	"io"
 	"net"
 	"os"
+	"simple-kv/pkg/client"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
 	"simple-kv/pkg/parsers"
 	"simple-kv/pkg/protos"
+	"strings"
+	"text/tabwriter"
 )
 
 var opts struct {
@@ -33,12 +41,15 @@ func main() {
 
 const ErrorSymbol = "<ERROR>"
 
+const ClientName = "simple-kv-cli"
+
 func Interact(hostname string, port string) error {
-	addr := fmt.Sprintf("%s:%s", hostname, port)
-	dial, err := net.Dial("tcp", addr)
+	addr := net.JoinHostPort(hostname, port)
+	conn, err := dial(addr)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = conn.Close() }()
 
 	reader := bufio.NewReader(os.Stdin)
 	parser := parsers.NewParser()
@@ -46,7 +57,15 @@ func Interact(hostname string, port string) error {
 		fmt.Printf("[%s]> ", addr)
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Printf("%s %s\n", ErrorSymbol, err.Error())
+			if err == io.EOF && strings.TrimSpace(line) == "" {
+				fmt.Println()
+				return nil
+			}
+			if err != io.EOF {
+				return err
+			}
+		}
+		if strings.TrimSpace(line) == "" {
 			continue
 		}
 
@@ -56,33 +75,95 @@ func Interact(hostname string, port string) error {
 			continue
 		}
 
-		err = req.Send(dial)
-		if err != nil {
-			fmt.Printf("%s fail to send command: req=%v, err=%v\n", ErrorSymbol, req, err)
-			continue
+		if conn.Broken() {
+			fmt.Printf("%s connection lost, reconnecting, the open transaction is aborted\n", ErrorSymbol)
+			if conn, err = dial(addr); err != nil {
+				fmt.Printf("%s %s\n", ErrorSymbol, err.Error())
+				return err
+			}
 		}
 
-		resp, err := protos.ParseCommand(dial)
+		table := &pairsTable{}
+		resp, err := conn.SendStream(req, table.Append).Wait()
 		if err != nil {
-			fmt.Printf("%s fail to parse command: req=%v, resp=%v, err=%v\n", ErrorSymbol, req, resp, err)
+			showError(err)
 			continue
 		}
 
+		if resp.Type == protos.End {
+			cursor, err := client.ReplyString(resp)
+			if err != nil {
+				showError(err)
+				continue
+			}
+			table.End(cursor)
+			continue
+		}
 		showResponse(resp)
 	}
 }
 
+func dial(addr string) (*client.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ClientDialTimeout)
+	defer cancel()
+	return client.DialContext(ctx, addr, ClientName)
+}
+
+func showError(err error) {
+	var serverErr *errs.Error
+	if errors.As(err, &serverErr) {
+		fmt.Printf("%s (%v) %s\n", ErrorSymbol, serverErr.Code(), serverErr.Message)
+		return
+	}
+	fmt.Printf("%s %s\n", ErrorSymbol, err.Error())
+}
+
 func showResponse(resp *protos.Command) {
 	switch resp.Type {
 	case protos.None:
 		break
-	case protos.Error:
-		fmt.Printf("%s %s\n", ErrorSymbol, resp.Payload[0])
+	case protos.Nil:
+		fmt.Println("(nil)")
 	case protos.String:
-		fmt.Printf("%s\n", resp.Payload[0])
+		val, err := client.ReplyString(resp)
+		if err != nil {
+			showError(err)
+			return
+		}
+		fmt.Printf("%s\n", val)
 	case protos.Strings:
-		fmt.Println(resp.Payload)
+		if len(resp.Payload) == 0 {
+			fmt.Println("(empty)")
+		}
+		for _, line := range resp.Payload {
+			fmt.Println(line)
+		}
 	default:
 		fmt.Printf("%s invalid response type: resp=%v\n", ErrorSymbol, resp)
 	}
 }
+
+// pairsTable prints the chunks of a streaming response once they arrive
+type pairsTable struct {
+	writer *tabwriter.Writer
+}
+
+func (t *pairsTable) Append(chunk *protos.Command) {
+	if t.writer == nil {
+		t.writer = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(t.writer, "KEY\tVALUE")
+	}
+	for i := 0; i+1 < len(chunk.Payload); i += 2 {
+		_, _ = fmt.Fprintf(t.writer, "%s\t%s\n", chunk.Payload[i], chunk.Payload[i+1])
+	}
+	_ = t.writer.Flush()
+}
+
+func (t *pairsTable) End(cursor string) {
+	if t.writer == nil {
+		t.Append(&protos.Command{})
+	}
+	if cursor != "" {
+		fmt.Printf("(more records, SCAN CURSOR %s)\n", cursor)
+	}
+}
@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
 	"github.com/jessevdk/go-flags"
+	"os"
+	"os/signal"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/logger"
 	"simple-kv/pkg/protos"
+	"syscall"
+	"time"
 )
 
 var opts struct {
-	Host string `value-name:"host" short:"h" long:"host" default:"localhost" description:"simple-kv server host"`
-	Port string `value-name:"port" short:"p" long:"port" default:"8081" description:"simple-kv server port"`
+	Host               string        `value-name:"host" short:"h" long:"host" default:"localhost" description:"simple-kv server host"`
+	Port               string        `value-name:"port" short:"p" long:"port" default:"8081" description:"simple-kv server port"`
+	DataDir            string        `value-name:"dir" short:"d" long:"data-dir" default:"data" description:"directory of the write-ahead log, keep data in memory only if empty"`
+	ShutdownTimeout    time.Duration `value-name:"duration" short:"t" long:"shutdown-timeout" default:"10s" description:"how long to wait for open transactions on SIGINT/SIGTERM before aborting them"`
+	GCInterval         time.Duration `value-name:"duration" long:"gc-interval" default:"50ms" description:"how often to collect the versions no transaction can see"`
+	DeadlockInterval   time.Duration `value-name:"duration" long:"deadlock-interval" default:"500ms" description:"how often to sweep the locks for deadlocks in the detect mode"`
+	CheckpointInterval time.Duration `value-name:"duration" long:"checkpoint-interval" default:"1m" description:"how often to checkpoint and truncate the write-ahead log"`
+	DeadlockMode       string        `value-name:"mode" long:"deadlock-mode" default:"detect" choice:"detect" choice:"wait-die" choice:"wound-wait" description:"how to handle deadlocks, detect cycles or prevent them by the age of transactions"`
+	VictimPolicy       string        `value-name:"policy" long:"victim-policy" default:"youngest" choice:"youngest" choice:"fewest-locks" choice:"smallest-write-set" choice:"lowest-priority" description:"how to choose the transaction to abort in a deadlock"`
 }
 
 func main() {
@@ -19,12 +33,40 @@ func main() {
 			panic(err)
 		}
 	}
+	config.ShutdownTimeout = opts.ShutdownTimeout
+	config.GCInterval = opts.GCInterval
+	config.DeadlockDetectInterval = opts.DeadlockInterval
+	config.CheckpointInterval = opts.CheckpointInterval
+	config.DeadlockMode = opts.DeadlockMode
+	config.DeadlockVictimPolicy = opts.VictimPolicy
 
-	server := protos.NewServer(opts.Host, opts.Port)
-	defer server.Close()
-
-	err = server.Run()
+	server, err := protos.NewServer(opts.Host, opts.Port, opts.DataDir)
 	if err != nil {
 		panic(err)
 	}
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Run()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-stopped:
+		_ = server.Close()
+		panic(err)
+	case sig := <-signals:
+		logger.Inst.Infow("signal received",
+			"signal", sig)
+	}
+	// a second signal kills the server at once
+	signal.Stop(signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err = server.Shutdown(ctx); err != nil {
+		os.Exit(1)
+	}
+	<-stopped
 }
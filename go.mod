@@ -3,7 +3,6 @@ module simple-kv
 go 1.18
 
 require (
-	github.com/cespare/xxhash v1.1.0
 	github.com/jessevdk/go-flags v1.5.0
 	go.uber.org/zap v1.23.0
 )
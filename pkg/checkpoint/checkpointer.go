@@ -0,0 +1,117 @@
+package checkpoint
+
+import (
+	"os"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/index"
+	"simple-kv/pkg/logger"
+	"simple-kv/pkg/modules"
+	"simple-kv/pkg/txns"
+	"simple-kv/pkg/values"
+	"simple-kv/pkg/wal"
+	"simple-kv/pkg/workers"
+)
+
+type Checkpointer struct {
+	Dir        string
+	Index      *index.SkipList
+	TxnManager modules.TxnManager
+	Log        *wal.Log
+	loop       *workers.Loop
+}
+
+func NewCheckpointer(dir string, index *index.SkipList, txnManager modules.TxnManager, log *wal.Log) *Checkpointer {
+	return &Checkpointer{
+		Dir:        dir,
+		Index:      index,
+		TxnManager: txnManager,
+		Log:        log,
+		loop:       workers.NewLoop(),
+	}
+}
+
+// Run checkpoints every config.CheckpointInterval until Stop is called
+func (c *Checkpointer) Run() {
+	c.loop.Run(config.CheckpointInterval, func() {
+		if err := c.Checkpoint(); err != nil {
+			logger.Inst.Warnw("fail to checkpoint",
+				"err", err)
+		}
+	})
+}
+
+func (c *Checkpointer) Stop() {
+	c.loop.Stop()
+}
+
+// Checkpoint writes all versions visible at a snapshot timestamp without blocking
+// writers, then drops the log segments and checkpoints that are covered by it.
+//
+// The snapshot is a transaction begun while commits are frozen, so the commits
+// before it are all installed and in the old segments, the ones after it are all
+// in the new segment, and GC keeps the versions it can see while it is active.
+func (c *Checkpointer) Checkpoint() error {
+	var (
+		snapshot *txns.Txn
+		seq      uint64
+		err      error
+	)
+	c.TxnManager.Freeze(func() {
+		snapshot = c.TxnManager.NewTxn()
+		seq, err = c.Log.Rotate()
+	})
+	defer c.TxnManager.Abort(snapshot)
+	if err != nil {
+		return err
+	}
+
+	writer, err := Create(c.Dir, snapshot.ID)
+	if err != nil {
+		return err
+	}
+	c.Index.Walk(func(key string, val *values.Value) bool {
+		version := val.Snapshot(snapshot.ID)
+		if version == nil {
+			return true
+		}
+		err = writer.Write(key, version.StartTime, version.Val)
+		return err == nil
+	})
+	if err == nil {
+		err = writer.Commit()
+	}
+	if err != nil {
+		writer.Abort()
+		return err
+	}
+
+	if err = c.Log.RemoveBefore(seq); err != nil {
+		return err
+	}
+	if err = c.removeBefore(snapshot.ID); err != nil {
+		return err
+	}
+
+	logger.Inst.Infow("checkpoint done",
+		"timestamp", snapshot.ID,
+		"entries", writer.Image.Entries,
+		"segment", seq)
+	return nil
+}
+
+func (c *Checkpointer) removeBefore(ts uint64) error {
+	timestamps, err := List(c.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range timestamps {
+		if t >= ts {
+			break
+		}
+		if err = os.Remove(FilePath(c.Dir, t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
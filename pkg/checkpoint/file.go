@@ -0,0 +1,234 @@
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	Magic   = uint32(0x534b5643) // "SKVC"
+	Version = uint16(3)
+
+	FileSuffix = ".ckpt"
+	TempSuffix = ".tmp"
+
+	FileHeaderLength   = 14
+	BlockHeaderLength  = 13
+	FileFooterLength   = 21
+	BlockSizeThreshold = 64 * 1024
+
+	blockMark  = byte(1)
+	footerMark = byte(0)
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrUnsupportedVersion is returned for a checkpoint written in another format version
+var ErrUnsupportedVersion = errors.New("unsupported checkpoint version")
+
+/*
+The checkpoint is also the bulk-load format. Entries are sorted by key and grouped
+into independently checksummed blocks, so that blocks are key-range partitions
+which can be decoded in parallel and then appended to the index in block order.
+
+<file>   := <magic:4> <version:2> <timestamp:8> <block>* <footer>
+<block>  := <1:1> <length:4> <count:4> <crc32c:4> <entry>*
+<entry>  := <length:4> <key> <commitID:8> <length:4> <value>
+<footer> := <0:1> <blocks:8> <entries:8> <crc32c:4>
+
+The footer checksum covers the file header and the counts in the footer.
+*/
+
+// Image describes a consistent snapshot of all visible versions at Timestamp
+type Image struct {
+	Path      string
+	Timestamp uint64
+	Blocks    uint64
+	Entries   uint64
+}
+
+func FilePath(dir string, ts uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", ts, FileSuffix))
+}
+
+// List lists the timestamps of checkpoints in `dir` in ascending order
+func List(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, FileSuffix) {
+			continue
+		}
+		ts, err := strconv.ParseUint(strings.TrimSuffix(name, FileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, ts)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// RemoveTemp deletes the temporary files of the checkpoints left unfinished by a crash
+func RemoveTemp(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, FileSuffix+TempSuffix) {
+			continue
+		}
+		if err = os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Writer struct {
+	Path    string
+	Image   *Image
+	file    *os.File
+	buf     *bufio.Writer
+	header  []byte
+	block   []byte
+	count   uint32
+	lastKey string
+}
+
+// Create writes to a temporary file which becomes visible only after Commit
+func Create(dir string, ts uint64) (*Writer, error) {
+	path := FilePath(dir, ts)
+	file, err := os.Create(path + TempSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		Path:   path,
+		Image:  &Image{Path: path, Timestamp: ts},
+		file:   file,
+		buf:    bufio.NewWriter(file),
+		header: make([]byte, FileHeaderLength),
+	}
+
+	binary.BigEndian.PutUint32(w.header, Magic)
+	binary.BigEndian.PutUint16(w.header[4:], Version)
+	binary.BigEndian.PutUint64(w.header[6:], ts)
+	if _, err = w.buf.Write(w.header); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends an entry, keys should be written in ascending order
+func (w *Writer) Write(key string, commitID uint64, val string) error {
+	if w.Image.Entries != 0 && key <= w.lastKey {
+		return fmt.Errorf("checkpoint keys out of order: last=%q, key=%q", w.lastKey, key)
+	}
+
+	buffer := make([]byte, 4+len(key)+8+4+len(val))
+	binary.BigEndian.PutUint32(buffer, uint32(len(key)))
+	copy(buffer[4:], key)
+	binary.BigEndian.PutUint64(buffer[4+len(key):], commitID)
+	binary.BigEndian.PutUint32(buffer[12+len(key):], uint32(len(val)))
+	copy(buffer[16+len(key):], val)
+	w.block = append(w.block, buffer...)
+	w.count++
+	w.lastKey = key
+	w.Image.Entries++
+
+	if len(w.block) >= BlockSizeThreshold {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+func (w *Writer) flushBlock() error {
+	if w.count == 0 {
+		return nil
+	}
+
+	header := make([]byte, BlockHeaderLength)
+	header[0] = blockMark
+	binary.BigEndian.PutUint32(header[1:], uint32(len(w.block)))
+	binary.BigEndian.PutUint32(header[5:], w.count)
+	binary.BigEndian.PutUint32(header[9:], crc32.Checksum(w.block, crcTable))
+	if _, err := w.buf.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.buf.Write(w.block); err != nil {
+		return err
+	}
+
+	w.block = w.block[:0]
+	w.count = 0
+	w.Image.Blocks++
+	return nil
+}
+
+// Commit writes the footer, fsync and renames the file to make it visible
+func (w *Writer) Commit() error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	footer := make([]byte, FileFooterLength)
+	footer[0] = footerMark
+	binary.BigEndian.PutUint64(footer[1:], w.Image.Blocks)
+	binary.BigEndian.PutUint64(footer[9:], w.Image.Entries)
+	binary.BigEndian.PutUint32(footer[17:], footerChecksum(w.header, footer))
+	if _, err := w.buf.Write(footer); err != nil {
+		return err
+	}
+
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.Path+TempSuffix, w.Path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(w.Path))
+}
+
+func (w *Writer) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.Path + TempSuffix)
+}
+
+func footerChecksum(header []byte, footer []byte) uint32 {
+	crc := crc32.Update(0, crcTable, header)
+	return crc32.Update(crc, crcTable, footer[1:17])
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
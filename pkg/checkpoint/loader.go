@@ -0,0 +1,252 @@
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"simple-kv/pkg/index"
+	"simple-kv/pkg/values"
+	"sync"
+)
+
+type block struct {
+	seq   int
+	count uint32
+	body  []byte
+}
+
+// run is the decoded records of a block, sorted by key
+type run struct {
+	keys []string
+	vals []*values.Value
+}
+
+// Load bulk-loads the checkpoint at `path` into the empty `index`.
+//
+// One goroutine reads and verifies the blocks, and `workers` goroutines decode
+// them and build the version chains of their key ranges. The runs are appended
+// to the index in key order only after the whole file turns out to be valid, so
+// an invalid checkpoint leaves the index untouched.
+func Load(path string, index *index.SkipList, workers int) (*Image, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		runs      = map[int]*run{}
+		decodeErr error
+		latch     sync.Mutex
+		done      sync.WaitGroup
+	)
+	blocks := make(chan *block, workers*2)
+	done.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer done.Done()
+			for b := range blocks {
+				r, err := decodeBlock(b, index)
+				latch.Lock()
+				runs[b.seq] = r
+				if err != nil && decodeErr == nil {
+					decodeErr = err
+				}
+				latch.Unlock()
+			}
+		}()
+	}
+
+	image, err := readBlocks(file, blocks)
+	done.Wait()
+	if err == nil {
+		err = decodeErr
+	}
+	if err == nil {
+		err = merge(runs, index)
+	}
+
+	if err != nil {
+		for _, r := range runs {
+			for _, val := range r.vals {
+				index.ValueManager.DelValue(val.ID)
+			}
+		}
+		return nil, err
+	}
+	image.Path = path
+	return image, nil
+}
+
+// LoadNewest loads the newest checkpoint in `dir`, or returns nil if there is none.
+// A checkpoint is renamed into place only after it is complete and synced, and
+// then the older checkpoints and the log before it are removed, so falling back
+// to an older one would lose data: an invalid newest checkpoint is an error.
+func LoadNewest(dir string, index *index.SkipList, workers int) (*Image, error) {
+	timestamps, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(timestamps) == 0 {
+		return nil, nil
+	}
+
+	path := FilePath(dir, timestamps[len(timestamps)-1])
+	image, err := Load(path, index, workers)
+	if err != nil {
+		return nil, fmt.Errorf("fail to load the newest checkpoint %s: %w, the log before it has been "+
+			"removed, restore the file from a backup or start with an empty data dir", path, err)
+	}
+	return image, nil
+}
+
+// readBlocks sends verified blocks to `blocks` in file order, and closes it at the end
+func readBlocks(file *os.File, blocks chan<- *block) (*Image, error) {
+	defer close(blocks)
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(file)
+
+	header := make([]byte, FileHeaderLength)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("fail to read checkpoint header: err=%v", err)
+	}
+	if magic := binary.BigEndian.Uint32(header); magic != Magic {
+		return nil, fmt.Errorf("invalid checkpoint magic: magic=%x", magic)
+	}
+	if version := binary.BigEndian.Uint16(header[4:]); version != Version {
+		return nil, fmt.Errorf("%w: expect=%d, got=%d", ErrUnsupportedVersion, Version, version)
+	}
+
+	image := &Image{Timestamp: binary.BigEndian.Uint64(header[6:])}
+	blockHeader := make([]byte, BlockHeaderLength)
+	for {
+		if _, err = io.ReadFull(reader, blockHeader[:1]); err != nil {
+			return nil, fmt.Errorf("fail to read checkpoint block: err=%v", err)
+		}
+		if blockHeader[0] == footerMark {
+			break
+		}
+		if blockHeader[0] != blockMark {
+			return nil, fmt.Errorf("invalid checkpoint block mark: mark=%d", blockHeader[0])
+		}
+
+		if _, err = io.ReadFull(reader, blockHeader[1:]); err != nil {
+			return nil, fmt.Errorf("fail to read checkpoint block: err=%v", err)
+		}
+		length := binary.BigEndian.Uint32(blockHeader[1:])
+		if int64(length) > stat.Size() {
+			return nil, fmt.Errorf("checkpoint block out of range: length=%d", length)
+		}
+		body := make([]byte, length)
+		if _, err = io.ReadFull(reader, body); err != nil {
+			return nil, fmt.Errorf("fail to read checkpoint block: err=%v", err)
+		}
+		if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(blockHeader[9:]) {
+			return nil, fmt.Errorf("checkpoint block checksum mismatched: block=%d", image.Blocks)
+		}
+
+		count := binary.BigEndian.Uint32(blockHeader[5:])
+		blocks <- &block{
+			seq:   int(image.Blocks),
+			count: count,
+			body:  body,
+		}
+		image.Blocks++
+		image.Entries += uint64(count)
+	}
+
+	footer := make([]byte, FileFooterLength)
+	if _, err = io.ReadFull(reader, footer[1:]); err != nil {
+		return nil, fmt.Errorf("fail to read checkpoint footer: err=%v", err)
+	}
+	if binary.BigEndian.Uint32(footer[17:]) != footerChecksum(header, footer) {
+		return nil, fmt.Errorf("checkpoint footer checksum mismatched")
+	}
+	if n := binary.BigEndian.Uint64(footer[1:]); n != image.Blocks {
+		return nil, fmt.Errorf("checkpoint block count mismatched: expect=%d, got=%d", n, image.Blocks)
+	}
+	if n := binary.BigEndian.Uint64(footer[9:]); n != image.Entries {
+		return nil, fmt.Errorf("checkpoint entry count mismatched: expect=%d, got=%d", n, image.Entries)
+	}
+	return image, nil
+}
+
+// decodeBlock builds the values of a block, it returns the partial run on error for cleaning up
+func decodeBlock(b *block, index *index.SkipList) (*run, error) {
+	r := &run{
+		keys: make([]string, 0, b.count),
+		vals: make([]*values.Value, 0, b.count),
+	}
+
+	body := b.body
+	field := func() ([]byte, bool) {
+		if len(body) < 4 || len(body) < 4+int(binary.BigEndian.Uint32(body)) {
+			return nil, false
+		}
+		l := 4 + int(binary.BigEndian.Uint32(body))
+		res := body[4:l]
+		body = body[l:]
+		return res, true
+	}
+
+	for i := uint32(0); i < b.count; i++ {
+		key, ok := field()
+		if !ok || len(body) < 8 {
+			return r, fmt.Errorf("checkpoint entry out of range: block=%d", b.seq)
+		}
+		commitID := binary.BigEndian.Uint64(body)
+		body = body[8:]
+		val, ok := field()
+		if !ok {
+			return r, fmt.Errorf("checkpoint entry out of range: block=%d", b.seq)
+		}
+		if len(key) == 0 || (len(r.keys) != 0 && string(key) <= r.keys[len(r.keys)-1]) {
+			return r, fmt.Errorf("checkpoint keys out of order: block=%d, key=%q", b.seq, key)
+		}
+
+		version := values.NewVersion(string(val))
+		version.Install(commitID)
+		value := index.ValueManager.NewValue(index.LockManager.NewRWLock(string(key)))
+		value.VersionHeader = version
+
+		r.keys = append(r.keys, string(key))
+		r.vals = append(r.vals, value)
+	}
+
+	if len(body) != 0 {
+		return r, fmt.Errorf("checkpoint block has trailing bytes: block=%d", b.seq)
+	}
+	return r, nil
+}
+
+func merge(runs map[int]*run, index *index.SkipList) error {
+	var lastKey string
+	for seq := 0; seq < len(runs); seq++ {
+		r := runs[seq]
+		if len(r.keys) == 0 {
+			continue
+		}
+		if r.keys[0] <= lastKey {
+			return fmt.Errorf("checkpoint keys out of order: block=%d, key=%q", seq, r.keys[0])
+		}
+		lastKey = r.keys[len(r.keys)-1]
+	}
+
+	for seq := 0; seq < len(runs); seq++ {
+		if err := index.BulkLoad(runs[seq].keys, runs[seq].vals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
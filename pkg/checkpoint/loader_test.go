@@ -0,0 +1,108 @@
+package checkpoint
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"simple-kv/pkg/index"
+	lockmanager "simple-kv/pkg/locks/manager"
+	valuemanager "simple-kv/pkg/values/manager"
+	"testing"
+)
+
+func key(i int) string {
+	return fmt.Sprintf("key-%08d", i)
+}
+
+func writeCheckpoint(t testing.TB, dir string, ts uint64, scale int) string {
+	writer, err := Create(dir, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= scale; i++ {
+		if err = writer.Write(key(i), uint64(i), fmt.Sprintf("value-%064d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return writer.Path
+}
+
+func newIndex() *index.SkipList {
+	return index.NewSkipList(valuemanager.NewValueManager(), lockmanager.NewLockManager())
+}
+
+func TestLoad(t *testing.T) {
+	const scale = 100000
+
+	path := writeCheckpoint(t, t.TempDir(), 42, scale)
+	s := newIndex()
+	image, err := Load(path, s, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if image.Timestamp != 42 || image.Entries != scale || image.Blocks < 2 {
+		t.Fatalf("Expect {42 %d >=2}, got %v\n", scale, image)
+	}
+
+	for i := 1; i <= scale; i++ {
+		val := s.Get(key(i))
+		if val == nil {
+			t.Fatalf("Expect non-nil, got nil: key=%d\n", i)
+		}
+		expect := fmt.Sprintf("value-%064d", i)
+		if version := val.VersionHeader; version.Val != expect || version.StartTime != uint64(i) {
+			t.Fatalf("Expect {%s %d}, got {%s %d}\n", expect, i, version.Val, version.StartTime)
+		}
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	writeCheckpoint(t, dir, 1, 1000)
+	path := writeCheckpoint(t, dir, 2, 100000)
+
+	// corrupt a byte in the middle of the newer checkpoint
+	file, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	stat, _ := file.Stat()
+	_, _ = file.WriteAt([]byte{0xff}, stat.Size()/2)
+	_ = file.Close()
+
+	s := newIndex()
+	if _, err := Load(path, s, 4); err == nil {
+		t.Fatalf("Expect err, got nil\n")
+	}
+	if val := s.Get(key(1)); val != nil {
+		t.Fatalf("Expect index untouched, got %v\n", val)
+	}
+
+	// the log before the newest checkpoint is gone, so the older one is not enough
+	if image, err := LoadNewest(dir, s, 4); err == nil {
+		t.Fatalf("Expect err, got %v\n", image)
+	}
+	if val := s.Get(key(1)); val != nil {
+		t.Fatalf("Expect index untouched, got %v\n", val)
+	}
+}
+
+func benchmarkLoad(b *testing.B, workers int) {
+	const scale = 1000000
+
+	path := writeCheckpoint(b, b.TempDir(), 1, scale)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Load(path, newIndex(), workers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoad_Serial(b *testing.B) {
+	benchmarkLoad(b, 1)
+}
+
+func BenchmarkLoad_Parallel(b *testing.B) {
+	benchmarkLoad(b, runtime.NumCPU())
+}
@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/protos"
+	"strconv"
+	"sync"
+)
+
+type Pair struct {
+	Key string
+	Val string
+}
+
+// Client is a pool of connections to one server, safe for concurrent use.
+// A request outside any transaction borrows a connection for the request only,
+// and a transaction keeps its connection until it is done. Broken connections
+// are dropped and dialed again on demand.
+type Client struct {
+	Addr string
+	Name string
+
+	// slots bounds the count of open connections
+	slots chan struct{}
+	idle  chan *Conn
+
+	latch  sync.Mutex
+	closed bool
+}
+
+func NewClient(addr string) *Client {
+	return &Client{
+		Addr:  addr,
+		Name:  ClientName,
+		slots: make(chan struct{}, config.ClientPoolSize),
+		idle:  make(chan *Conn, config.ClientPoolSize),
+	}
+}
+
+// Close closes the idle connections, and the ones in use once they are released
+func (c *Client) Close() error {
+	c.latch.Lock()
+	c.closed = true
+	c.latch.Unlock()
+
+	for {
+		select {
+		case conn := <-c.idle:
+			c.drop(conn)
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) acquire(ctx context.Context) (*Conn, error) {
+	c.latch.Lock()
+	closed := c.closed
+	c.latch.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	for {
+		var conn *Conn
+		select {
+		case conn = <-c.idle:
+		default:
+			select {
+			case conn = <-c.idle:
+			case c.slots <- struct{}{}:
+				return c.dial(ctx)
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if !conn.Broken() {
+			return conn, nil
+		}
+		c.drop(conn)
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (*Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.ClientDialTimeout)
+	defer cancel()
+
+	conn, err := DialContext(ctx, c.Addr, c.Name)
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *Client) release(conn *Conn) {
+	c.latch.Lock()
+	closed := c.closed
+	c.latch.Unlock()
+
+	if closed || conn.Broken() {
+		c.drop(conn)
+		return
+	}
+	c.idle <- conn
+}
+
+func (c *Client) drop(conn *Conn) {
+	_ = conn.Close()
+	<-c.slots
+}
+
+// do runs `fn` on a pooled connection, and runs it again on another one if the
+// request was not sent since the connection was broken
+func (c *Client) do(ctx context.Context, fn func(conn *Conn) error) error {
+	for {
+		conn, err := c.acquire(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = fn(conn)
+		c.release(conn)
+		if !isNotSent(err) {
+			return err
+		}
+	}
+}
+
+func (c *Client) Get(ctx context.Context, key string) (val string, found bool, err error) {
+	err = c.do(ctx, func(conn *Conn) error {
+		val, found, err = get(ctx, conn, key)
+		return err
+	})
+	return
+}
+
+func (c *Client) Put(ctx context.Context, key string, val string) error {
+	return c.do(ctx, func(conn *Conn) error {
+		return call(ctx, conn, protos.NewCommand(protos.Put, []string{key, val}))
+	})
+}
+
+func (c *Client) Del(ctx context.Context, key string) error {
+	return c.do(ctx, func(conn *Conn) error {
+		return call(ctx, conn, protos.NewCommand(protos.Del, []string{key}))
+	})
+}
+
+// Scan returns `count` records from `key` on
+func (c *Client) Scan(ctx context.Context, key string, count int) (pairs []Pair, err error) {
+	err = c.do(ctx, func(conn *Conn) error {
+		pairs, err = scan(ctx, conn, protos.NewCommand(protos.Scan, []string{key, strconv.Itoa(count)}))
+		return err
+	})
+	return
+}
+
+// Range returns the records in [start, end), an empty end means no upper bound
+// and a limit <= 0 means no limit
+func (c *Client) Range(ctx context.Context, start string, end string, limit int, reverse bool) (pairs []Pair, err error) {
+	err = c.do(ctx, func(conn *Conn) error {
+		pairs, err = scan(ctx, conn, rangeCommand(start, end, limit, reverse))
+		return err
+	})
+	return
+}
+
+// ScanPrefix returns `count` records starting with `prefix`, a count <= 0 means no limit
+func (c *Client) ScanPrefix(ctx context.Context, prefix string, count int) (pairs []Pair, err error) {
+	err = c.do(ctx, func(conn *Conn) error {
+		pairs, err = scan(ctx, conn, protos.NewCommand(protos.PScan, []string{prefix, strconv.Itoa(count)}))
+		return err
+	})
+	return
+}
+
+// runTxn runs `fn` in a transaction, and commits it unless `fn` fails
+func (c *Client) runTxn(ctx context.Context, fn func(txn *Txn) error) error {
+	txn, err := c.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if err = fn(txn); err != nil {
+		_ = txn.Abort(ctx)
+		return err
+	}
+	return txn.Commit(ctx)
+}
+
+// Begin starts a transaction pinned to a connection until Commit or Abort
+func (c *Client) Begin(ctx context.Context) (*Txn, error) {
+	for {
+		conn, err := c.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		err = call(ctx, conn, protos.NewCommand(protos.Begin, nil))
+		if err == nil {
+			return &Txn{client: c, conn: conn}, nil
+		}
+		c.finish(conn, err)
+		if !isNotSent(err) {
+			return nil, err
+		}
+	}
+}
+
+// finish gives back the connection of a transaction. The connection is dropped
+// unless the server has replied, since the session state is unknown then.
+func (c *Client) finish(conn *Conn, err error) {
+	var serverErr *errs.Error
+	if err != nil && !errors.As(err, &serverErr) {
+		c.drop(conn)
+		return
+	}
+	c.release(conn)
+}
+
+func call(ctx context.Context, conn *Conn, req *protos.Command) error {
+	_, err := conn.Send(req).WaitContext(ctx)
+	return err
+}
+
+func get(ctx context.Context, conn *Conn, key string) (string, bool, error) {
+	resp, err := conn.Get(key).WaitContext(ctx)
+	if errs.Is(err, errs.NoSuchKey) && conn.Server.Version < protos.NilVersion {
+		// the servers before NIL answer a missing key with an error
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if resp.Type == protos.Nil {
+		return "", false, nil
+	}
+	val, err := ReplyString(resp)
+	if err != nil {
+		return "", false, err
+	}
+	return val, true, nil
+}
+
+// ReplyString returns the only string of a String or End reply, or a protocol
+// error if the reply is malformed
+func ReplyString(resp *protos.Command) (string, error) {
+	if len(resp.Payload) != 1 {
+		return "", errs.New(errs.Protocol, "malformed reply: type=%v, strings=%d", resp.Type, len(resp.Payload))
+	}
+	return resp.Payload[0], nil
+}
+
+// scan reads every page of the scan `req`, the pages after the first one are
+// fetched by the cursor if the scan runs in a transaction
+func scan(ctx context.Context, conn *Conn, req *protos.Command) ([]Pair, error) {
+	var pairs []Pair
+	for {
+		future := conn.Send(req)
+		resp, err := future.WaitContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, chunk := range future.Chunks {
+			for i := 0; i+1 < len(chunk.Payload); i += 2 {
+				pairs = append(pairs, Pair{Key: chunk.Payload[i], Val: chunk.Payload[i+1]})
+			}
+		}
+		if resp.Type != protos.End {
+			return pairs, nil
+		}
+		cursor, err := ReplyString(resp)
+		if err != nil {
+			return nil, err
+		}
+		if cursor == "" {
+			return pairs, nil
+		}
+		req = protos.NewCommand(protos.Fetch, []string{cursor})
+	}
+}
+
+func rangeCommand(start string, end string, limit int, reverse bool) *protos.Command {
+	r := "0"
+	if reverse {
+		r = "1"
+	}
+	return protos.NewCommand(protos.Range, []string{start, end, strconv.Itoa(limit), r})
+}
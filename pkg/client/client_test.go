@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/engines"
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/protos"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_Concurrency(t *testing.T) {
+	c := NewClient(serve(t))
+	defer c.Close()
+	ctx := context.Background()
+
+	done := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			for j := 0; j < 20; j++ {
+				key := fmt.Sprintf("%02d-%02d", i, j)
+				if err := c.Put(ctx, key, key); err != nil {
+					t.Error(err)
+					return
+				}
+				val, found, err := c.Get(ctx, key)
+				if err != nil || !found || val != key {
+					t.Errorf("Expect %s, got %s (found=%v, err=%v)\n", key, val, found, err)
+					return
+				}
+			}
+		}(i)
+	}
+	done.Wait()
+
+	if len(c.slots) > config.ClientPoolSize || len(c.idle) != len(c.slots) {
+		t.Errorf("Expect at most %d idle connections, got %d of %d\n", config.ClientPoolSize, len(c.idle), len(c.slots))
+	}
+}
+
+func TestClient_Txn(t *testing.T) {
+	c := NewClient(serve(t))
+	defer c.Close()
+	ctx := context.Background()
+
+	txn, err := c.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	_ = txn.Put(ctx, "A", "1")
+	if val, found, _ := txn.Get(ctx, "A"); !found || val != "1" {
+		t.Errorf("Expect 1 in the txn, got %s\n", val)
+	}
+	if _, found, _ := c.Get(ctx, "A"); found {
+		t.Errorf("Expect not found out of the txn\n")
+	}
+	if err = txn.Commit(ctx); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	if err = txn.Commit(ctx); err != ErrTxnDone {
+		t.Errorf("Expect %v, got %v\n", ErrTxnDone, err)
+	}
+
+	txn, _ = c.Begin(ctx)
+	_ = txn.Put(ctx, "A", "2")
+	_ = txn.Abort(ctx)
+	if val, _, _ := c.Get(ctx, "A"); val != "1" {
+		t.Errorf("Expect 1 after abort, got %s\n", val)
+	}
+}
+
+func TestClient_Scan(t *testing.T) {
+	pageSize := config.ScanPageSize
+	config.ScanPageSize = 10
+	defer func() { config.ScanPageSize = pageSize }()
+
+	c := NewClient(serve(t))
+	defer c.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 35; i++ {
+		_ = c.Put(ctx, fmt.Sprintf("key-%02d", i), "v")
+	}
+
+	pairs, err := c.Scan(ctx, "key-03", 30)
+	if err != nil || len(pairs) != 30 || pairs[0].Key != "key-03" || pairs[29].Key != "key-32" {
+		t.Errorf("Expect key-03 to key-32, got %d pairs (err=%v)\n", len(pairs), err)
+	}
+
+	pairs, err = c.Range(ctx, "key-10", "", 0, true)
+	if err != nil || len(pairs) != 25 || pairs[0].Key != "key-34" || pairs[24].Key != "key-10" {
+		t.Errorf("Expect key-34 down to key-10, got %d pairs (err=%v)\n", len(pairs), err)
+	}
+
+	pairs, err = c.ScanPrefix(ctx, "key-1", 0)
+	if err != nil || len(pairs) != 10 {
+		t.Errorf("Expect 10 pairs, got %d (err=%v)\n", len(pairs), err)
+	}
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	defer listener.Close()
+
+	engine := engines.NewStringEngine().Run()
+	defer engine.Stop()
+	var (
+		latch sync.Mutex
+		conns []net.Conn
+	)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			latch.Lock()
+			conns = append(conns, conn)
+			latch.Unlock()
+			go protos.NewHandler(engine).Handle(conn)
+		}
+	}()
+
+	c := NewClient(listener.Addr().String())
+	defer c.Close()
+	ctx := context.Background()
+	if err = c.Put(ctx, "A", "1"); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+
+	// the server drops every connection
+	latch.Lock()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	latch.Unlock()
+	time.Sleep(100 * time.Millisecond)
+
+	if val, _, err := c.Get(ctx, "A"); err != nil || val != "1" {
+		t.Errorf("Expect 1 after reconnecting, got %s (err=%v)\n", val, err)
+	}
+}
+
+func TestClient_Deadline(t *testing.T) {
+	c := NewClient(serve(t))
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	time.Sleep(time.Millisecond)
+
+	if _, _, err := c.Get(ctx, "A"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expect %v, got %v\n", context.DeadlineExceeded, err)
+	}
+}
+
+func TestReplyString(t *testing.T) {
+	for _, payload := range [][]string{nil, {"a", "b"}} {
+		resp := protos.NewCommand(protos.String, payload)
+		if _, err := ReplyString(resp); !errs.Is(err, errs.Protocol) {
+			t.Errorf("Expect %v, got %v\n", errs.Protocol, err)
+		}
+	}
+	if val, err := ReplyString(protos.NewCommand(protos.End, []string{"cursor"})); err != nil || val != "cursor" {
+		t.Errorf("Expect cursor, got %s (err=%v)\n", val, err)
+	}
+}
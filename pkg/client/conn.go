@@ -0,0 +1,317 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"simple-kv/pkg/protos"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClosed  = errors.New("connection closed")
+	ErrTxnDone = errors.New("transaction has been done")
+)
+
+// notSentError is a request failed before any byte was written, so it is safe
+// to send it again on another connection
+type notSentError struct {
+	err error
+}
+
+func (e *notSentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notSentError) Unwrap() error {
+	return e.err
+}
+
+func isNotSent(err error) bool {
+	var notSent *notSentError
+	return errors.As(err, &notSent)
+}
+
+// Conn pipelines requests on one connection: a request is sent without waiting
+// for the replies of the previous ones, and its reply is delivered to a Future
+// by the request ID.
+type Conn struct {
+	// Server is the handshake answered by the server
+	Server *protos.Handshake
+
+	conn net.Conn
+
+	// writeLatch keeps the frames of requests from interleaving
+	writeLatch sync.Mutex
+	writer     *bufio.Writer
+	// last is the latest request, which must be replied before sending another
+	// one if the server does not support pipelining
+	last *Future
+
+	latch   sync.Mutex
+	nextID  uint64
+	pending map[uint64]*Future
+	err     error
+}
+
+const ClientName = "simple-kv-go"
+
+func Dial(addr string) (*Conn, error) {
+	return DialContext(context.Background(), addr, ClientName)
+}
+
+// DialContext connects to `addr` as client `name`, the context limits the
+// dialing and the handshake
+func DialContext(ctx context.Context, addr string, name string) (*Conn, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := NewConn(conn, name)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewConn does the handshake on the connection as client `name`
+func NewConn(conn net.Conn, name string) (*Conn, error) {
+	c := &Conn{
+		conn:    conn,
+		writer:  bufio.NewWriter(conn),
+		nextID:  protos.ConnectionID + 1,
+		pending: map[uint64]*Future{},
+	}
+
+	reader := bufio.NewReader(conn)
+	if err := c.handshake(reader, name); err != nil {
+		return nil, err
+	}
+	go c.receive(reader)
+	return c, nil
+}
+
+func (c *Conn) handshake(reader io.Reader, name string) error {
+	err := protos.NewHandshake(name, protos.FeaturePipelining).Command().Send(c.writer)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := protos.ParseCommand(reader)
+	if err != nil {
+		return err
+	}
+	if resp.Type == protos.Error {
+		return protos.ToError(resp)
+	}
+	c.Server, err = protos.ParseHandshake(resp)
+	if err != nil {
+		return err
+	}
+
+	// the deadline of dialing does not apply to the requests
+	return c.conn.SetDeadline(time.Time{})
+}
+
+// Send sends the request and returns the Future of its reply at once
+func (c *Conn) Send(req *protos.Command) *Future {
+	return c.SendStream(req, nil)
+}
+
+// SendStream is Send, but the chunks of a streaming reply are passed to `fn`
+// once they arrive instead of being kept in the Future
+func (c *Conn) SendStream(req *protos.Command, fn func(chunk *protos.Command)) *Future {
+	future := newFuture()
+	future.onChunk = fn
+
+	c.latch.Lock()
+	if c.err != nil {
+		err := c.err
+		c.latch.Unlock()
+		future.complete(nil, &notSentError{err: err})
+		return future
+	}
+	req.ID = c.nextID
+	c.nextID++
+	c.pending[req.ID] = future
+	c.latch.Unlock()
+
+	c.writeLatch.Lock()
+	if c.last != nil && !c.Server.Has(protos.FeaturePipelining) {
+		<-c.last.done
+	}
+	c.last = future
+	err := req.Send(c.writer)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	c.writeLatch.Unlock()
+
+	if err != nil {
+		c.latch.Lock()
+		_, ok := c.pending[req.ID]
+		delete(c.pending, req.ID)
+		c.latch.Unlock()
+
+		// or it has been failed by the receiver
+		if ok {
+			future.complete(nil, err)
+		}
+	}
+	return future
+}
+
+func (c *Conn) Get(key string) *Future {
+	return c.Send(protos.NewCommand(protos.Get, []string{key}))
+}
+
+func (c *Conn) Put(key string, val string) *Future {
+	return c.Send(protos.NewCommand(protos.Put, []string{key, val}))
+}
+
+func (c *Conn) Del(key string) *Future {
+	return c.Send(protos.NewCommand(protos.Del, []string{key}))
+}
+
+// Broken tells whether the connection can not send requests any more
+func (c *Conn) Broken() bool {
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	return c.err != nil
+}
+
+// Close closes the connection, and fails the requests still waiting for replies
+func (c *Conn) Close() error {
+	c.latch.Lock()
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	c.latch.Unlock()
+	return c.conn.Close()
+}
+
+// receive dispatches the replies to their futures until the connection fails
+func (c *Conn) receive(reader io.Reader) {
+	for {
+		resp, err := protos.ParseCommand(reader)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+
+		if resp.ID == protos.ConnectionID && resp.Type == protos.Error {
+			c.fail(protos.ToError(resp))
+			_ = c.conn.Close()
+			return
+		}
+
+		c.latch.Lock()
+		future := c.pending[resp.ID]
+		if future != nil && resp.Type != protos.Chunk {
+			delete(c.pending, resp.ID)
+		}
+		c.latch.Unlock()
+
+		if future == nil {
+			c.fail(fmt.Errorf("reply to no request: id=%d", resp.ID))
+			_ = c.conn.Close()
+			return
+		}
+
+		if resp.Type == protos.Chunk {
+			future.chunk(resp)
+			continue
+		}
+		future.complete(resp, nil)
+	}
+}
+
+func (c *Conn) fail(err error) {
+	c.latch.Lock()
+	if c.err == nil {
+		if err == io.EOF {
+			err = ErrClosed
+		}
+		c.err = err
+	}
+	err = c.err
+	pending := c.pending
+	c.pending = map[uint64]*Future{}
+	c.latch.Unlock()
+
+	for _, future := range pending {
+		future.complete(nil, err)
+	}
+}
+
+// Future is the reply of a request which may not have arrived yet
+type Future struct {
+	// Chunks are the chunks of a streaming reply
+	Chunks []*protos.Command
+
+	onChunk func(chunk *protos.Command)
+	done    chan struct{}
+	resp    *protos.Command
+	err     error
+}
+
+func newFuture() *Future {
+	return &Future{
+		done: make(chan struct{}),
+	}
+}
+
+func (f *Future) chunk(chunk *protos.Command) {
+	if f.onChunk != nil {
+		f.onChunk(chunk)
+		return
+	}
+	f.Chunks = append(f.Chunks, chunk)
+}
+
+func (f *Future) complete(resp *protos.Command, err error) {
+	f.resp = resp
+	f.err = err
+	close(f.done)
+}
+
+// Wait blocks until the reply arrives. An Error reply is returned as an *errs.Error.
+func (f *Future) Wait() (*protos.Command, error) {
+	return f.WaitContext(context.Background())
+}
+
+// WaitContext is Wait, but gives up once the context is done. The reply is
+// dropped if it arrives later.
+func (f *Future) WaitContext(ctx context.Context) (*protos.Command, error) {
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.resp.Type == protos.Error {
+		return nil, protos.ToError(f.resp)
+	}
+	return f.resp, nil
+}
+
+// Done is closed once the reply arrives
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"encoding/binary"
+	"net"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/engines"
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/protos"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func serve(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	engine := engines.NewStringEngine().Run()
+	t.Cleanup(engine.Stop)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go protos.NewHandler(engine).Handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestConn_Pipeline(t *testing.T) {
+	conn, err := Dial(serve(t))
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	defer conn.Close()
+
+	n := 500
+	puts := make([]*Future, n)
+	for i := 0; i < n; i++ {
+		puts[i] = conn.Put(strconv.Itoa(i), strconv.Itoa(i*i))
+	}
+	gets := make([]*Future, n)
+	for i := 0; i < n; i++ {
+		gets[i] = conn.Get(strconv.Itoa(i))
+	}
+	missing := conn.Get("missing")
+
+	for i := 0; i < n; i++ {
+		if _, err := puts[i].Wait(); err != nil {
+			t.Errorf("Expect nil, got %v\n", err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		resp, err := gets[i].Wait()
+		if err != nil {
+			t.Errorf("Expect nil, got %v\n", err)
+		} else if resp.Payload[0] != strconv.Itoa(i*i) {
+			t.Errorf("Expect %d, got %v\n", i*i, resp.Payload[0])
+		}
+	}
+	if resp, err := missing.Wait(); err != nil || resp.Type != protos.Nil {
+		t.Errorf("Expect NIL, got %v (err=%v)\n", resp, err)
+	}
+	if _, err := conn.Send(protos.NewCommand(protos.Scan, []string{"0", "x"})).Wait(); !errs.Is(err, errs.InvalidArgument) {
+		t.Errorf("Expect %v, got %v\n", errs.InvalidArgument, err)
+	}
+}
+
+func TestConn_Stream(t *testing.T) {
+	conn, err := Dial(serve(t))
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 250; i++ {
+		conn.Put(strconv.Itoa(1000+i), "v")
+	}
+	future := conn.Send(protos.NewCommand(protos.Scan, []string{"1000", "250"}))
+	resp, err := future.Wait()
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+
+	count := 0
+	for _, chunk := range future.Chunks {
+		count += len(chunk.Payload) / 2
+	}
+	if resp.Type != protos.End || count != 250 {
+		t.Errorf("Expect END after 250 records, got %v after %d\n", resp.Type, count)
+	}
+}
+
+func TestConn_Closed(t *testing.T) {
+	conn, err := Dial(serve(t))
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	_ = conn.Close()
+
+	if _, err := conn.Get("key").Wait(); err == nil {
+		t.Errorf("Expect error, got nil\n")
+	}
+}
+
+func TestConn_ConnectionError(t *testing.T) {
+	conn, err := Dial(serve(t))
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	defer conn.Close()
+
+	future := newFuture()
+	conn.latch.Lock()
+	conn.pending[7] = future
+	conn.latch.Unlock()
+
+	// a header of a frame too large, which the server can not answer by its ID
+	header := make([]byte, protos.CommandHeaderLength)
+	binary.BigEndian.PutUint64(header, config.MaxFrameSize+1)
+	header[8] = byte(protos.Put)
+	binary.BigEndian.PutUint64(header[9:], 7)
+	if _, err = conn.conn.Write(header); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+
+	if _, err = future.Wait(); !errs.Is(err, errs.Protocol) || !strings.Contains(err.Error(), "frame too large") {
+		t.Errorf("Expect the error of the server, got %v\n", err)
+	}
+	if !conn.Broken() {
+		t.Errorf("Expect the connection broken\n")
+	}
+}
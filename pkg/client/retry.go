@@ -0,0 +1,52 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
+	"time"
+)
+
+// RunInTxn runs `fn` in a transaction and commits it. If the transaction fails
+// with a retryable error, such as being a deadlock victim or timing out on a lock,
+// it is aborted and run again after a backoff, up to config.TxnRetryAttempts times.
+// Any other error aborts the transaction and is returned at once.
+func (c *Client) RunInTxn(ctx context.Context, fn func(txn *Txn) error) error {
+	var err error
+	for attempt := 0; attempt < config.TxnRetryAttempts; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, backoff(attempt)); err != nil {
+				return err
+			}
+		}
+
+		err = c.runTxn(ctx, fn)
+		if !errs.CodeOf(err).Retryable() {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff doubles the delay on every attempt up to the max delay, with a random
+// jitter in the upper half so that the conflicting transactions do not meet again
+func backoff(attempt int) time.Duration {
+	delay := config.TxnRetryMaxDelay
+	if attempt < 32 && config.TxnRetryBaseDelay<<(attempt-1) < delay {
+		delay = config.TxnRetryBaseDelay << (attempt - 1)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
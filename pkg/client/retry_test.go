@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClient_RunInTxn_Deadlock(t *testing.T) {
+	c := NewClient(serve(t))
+	defer c.Close()
+	ctx := context.Background()
+
+	var attempts int64
+	latch := sync.WaitGroup{}
+	latch.Add(2)
+	transfer := func(from string, to string) error {
+		first := true
+		return c.RunInTxn(ctx, func(txn *Txn) error {
+			atomic.AddInt64(&attempts, 1)
+			if err := txn.Put(ctx, from, "locked"); err != nil {
+				return err
+			}
+			// both transactions hold one key before taking the other one
+			if first {
+				first = false
+				latch.Done()
+				latch.Wait()
+			}
+			return txn.Put(ctx, to, from)
+		})
+	}
+
+	done := sync.WaitGroup{}
+	done.Add(2)
+	for _, keys := range [][2]string{{"A", "B"}, {"B", "A"}} {
+		go func(from string, to string) {
+			defer done.Done()
+			if err := transfer(from, to); err != nil {
+				t.Errorf("Expect nil, got %v\n", err)
+			}
+		}(keys[0], keys[1])
+	}
+	done.Wait()
+
+	if attempts < 3 {
+		t.Errorf("Expect the deadlock victim retried, got %d attempts\n", attempts)
+	}
+}
+
+func TestClient_RunInTxn_NotRetryable(t *testing.T) {
+	c := NewClient(serve(t))
+	defer c.Close()
+	ctx := context.Background()
+
+	attempts := 0
+	failure := errors.New("failure")
+	err := c.RunInTxn(ctx, func(txn *Txn) error {
+		attempts++
+		_ = txn.Put(ctx, "A", "1")
+		return failure
+	})
+	if err != failure || attempts != 1 {
+		t.Errorf("Expect %v after 1 attempt, got %v after %d\n", failure, err, attempts)
+	}
+	if _, found, _ := c.Get(ctx, "A"); found {
+		t.Errorf("Expect the txn aborted\n")
+	}
+
+	retryAttempts := config.TxnRetryAttempts
+	config.TxnRetryAttempts = 3
+	defer func() { config.TxnRetryAttempts = retryAttempts }()
+
+	attempts = 0
+	err = c.RunInTxn(ctx, func(txn *Txn) error {
+		attempts++
+		return errs.New(errs.LockTimeout, "lock timeout")
+	})
+	if !errs.Is(err, errs.LockTimeout) || attempts != 3 {
+		t.Errorf("Expect %v after retries, got %v after %d\n", errs.LockTimeout, err, attempts)
+	}
+}
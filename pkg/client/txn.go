@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"simple-kv/pkg/protos"
+	"strconv"
+)
+
+// Txn is a transaction on a connection borrowed from the Client, which is given
+// back once the transaction commits or aborts. It is not safe for concurrent use.
+type Txn struct {
+	client *Client
+	conn   *Conn
+}
+
+func (t *Txn) Get(ctx context.Context, key string) (string, bool, error) {
+	if t.conn == nil {
+		return "", false, ErrTxnDone
+	}
+	return get(ctx, t.conn, key)
+}
+
+func (t *Txn) Put(ctx context.Context, key string, val string) error {
+	return t.call(ctx, protos.NewCommand(protos.Put, []string{key, val}))
+}
+
+func (t *Txn) Del(ctx context.Context, key string) error {
+	return t.call(ctx, protos.NewCommand(protos.Del, []string{key}))
+}
+
+// Scan returns `count` records from `key` on
+func (t *Txn) Scan(ctx context.Context, key string, count int) ([]Pair, error) {
+	return t.scan(ctx, protos.NewCommand(protos.Scan, []string{key, strconv.Itoa(count)}))
+}
+
+// Range returns the records in [start, end), an empty end means no upper bound
+// and a limit <= 0 means no limit
+func (t *Txn) Range(ctx context.Context, start string, end string, limit int, reverse bool) ([]Pair, error) {
+	return t.scan(ctx, rangeCommand(start, end, limit, reverse))
+}
+
+// ScanPrefix returns `count` records starting with `prefix`, a count <= 0 means no limit
+func (t *Txn) ScanPrefix(ctx context.Context, prefix string, count int) ([]Pair, error) {
+	return t.scan(ctx, protos.NewCommand(protos.PScan, []string{prefix, strconv.Itoa(count)}))
+}
+
+func (t *Txn) Commit(ctx context.Context) error {
+	return t.finish(ctx, protos.Commit)
+}
+
+func (t *Txn) Abort(ctx context.Context) error {
+	return t.finish(ctx, protos.Abort)
+}
+
+func (t *Txn) call(ctx context.Context, req *protos.Command) error {
+	if t.conn == nil {
+		return ErrTxnDone
+	}
+	return call(ctx, t.conn, req)
+}
+
+func (t *Txn) scan(ctx context.Context, req *protos.Command) ([]Pair, error) {
+	if t.conn == nil {
+		return nil, ErrTxnDone
+	}
+	return scan(ctx, t.conn, req)
+}
+
+func (t *Txn) finish(ctx context.Context, typ protos.CommandType) error {
+	if t.conn == nil {
+		return ErrTxnDone
+	}
+
+	err := call(ctx, t.conn, protos.NewCommand(typ, nil))
+	t.client.finish(t.conn, err)
+	t.conn = nil
+	return err
+}
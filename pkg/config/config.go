@@ -1,6 +1,42 @@
 package config
 
+import (
+	"runtime"
+	"time"
+)
+
 var (
 	SkipListMaxLevel = 16
 	SkipListProp     = 0.25
+
+	GCInterval             = 50 * time.Millisecond
+	DeadlockDetectInterval = 500 * time.Millisecond
+	CheckpointInterval     = time.Minute
+	RecoveryWorkers        = runtime.NumCPU()
+
+	// LockTimeout is the default lock wait timeout of transactions, 0 means no timeout
+	LockTimeout time.Duration = 0
+	// DeadlockMode is how to handle deadlocks: detect, wait-die or wound-wait
+	DeadlockMode = "detect"
+	// DeadlockVictimPolicy names the policy to choose the txn to abort in a deadlock
+	DeadlockVictimPolicy = "youngest"
+	// DeadlockReportSize is the count of the recent deadlocks kept to show
+	DeadlockReportSize = 16
+
+	ScanPageSize  = 1000
+	ScanChunkSize = 100
+
+	// MaxFrameSize is the max payload length of a frame in bytes
+	MaxFrameSize uint64 = 64 << 20
+
+	ClientPoolSize    = 16
+	ClientDialTimeout = 5 * time.Second
+
+	// TxnRetryAttempts is the max times RunInTxn runs a transaction
+	TxnRetryAttempts  = 10
+	TxnRetryBaseDelay = 10 * time.Millisecond
+	TxnRetryMaxDelay  = time.Second
+
+	// ShutdownTimeout is how long the server waits for the open transactions on shutdown
+	ShutdownTimeout = 10 * time.Second
 )
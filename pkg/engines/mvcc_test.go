@@ -1,6 +1,10 @@
 package engines
 
 import (
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/locks"
+	"simple-kv/pkg/txns"
 	"strconv"
 	"sync"
 	"testing"
@@ -14,15 +18,15 @@ func Test_Basic(t *testing.T) {
 	_ = engine.Put(txn1, 30, "30")
 
 	txn2 := engine.NewTxn()
-	val, err := engine.Get(txn2, 30)
-	if err == nil {
-		t.Errorf("Expect err, got %v\n", val)
+	val, found, err := engine.Get(txn2, 30)
+	if err != nil || found {
+		t.Errorf("Expect not found, got %v (err=%v)\n", val, err)
 	}
 	txn2.Commit()
 	txn1.Commit()
 
 	txn3 := engine.NewTxn()
-	val, err = engine.Get(txn3, 30)
+	val, _, err = engine.Get(txn3, 30)
 	if val != "30" {
 		t.Errorf("Expect 30, got %v (err=%v)\n", val, err)
 	}
@@ -43,7 +47,7 @@ func Test_Basic2(t *testing.T) {
 	}()
 
 	txn3 := engine.NewTxn()
-	val, _ := engine.Get(txn3, 30)
+	val, _, _ := engine.Get(txn3, 30)
 	if val != "30" {
 		t.Errorf("Expect 30, got %v\n", val)
 	}
@@ -52,6 +56,7 @@ func Test_Basic2(t *testing.T) {
 
 func Test_Deadlock(t *testing.T) {
 	engine := NewUint64Engine().Run()
+	defer engine.Stop()
 
 	done := sync.WaitGroup{}
 	done.Add(2)
@@ -91,9 +96,145 @@ func Test_Deadlock(t *testing.T) {
 	done.Wait()
 }
 
+// deadlock runs txn1 and txn2 into a deadlock and returns the victim, txn1
+// requests the lock of txn2 first unless `reversed`
+func deadlock(engine *Uint64Engine, txn1 *txns.Txn, txn2 *txns.Txn, reversed bool) *txns.Txn {
+	_ = engine.Put(txn1, 30, "31")
+	_ = engine.Put(txn2, 40, "42")
+
+	victims := make(chan *txns.Txn, 2)
+	done := sync.WaitGroup{}
+	done.Add(2)
+	lock := func(txn *txns.Txn, key uint64) {
+		defer done.Done()
+		if err := engine.Put(txn, key, "0"); errs.Is(err, errs.DeadlockVictim) {
+			victims <- txn
+			return
+		}
+		_ = txn.Commit()
+	}
+	if reversed {
+		go lock(txn2, 30)
+		time.Sleep(50 * time.Millisecond)
+		go lock(txn1, 40)
+	} else {
+		go lock(txn1, 40)
+		time.Sleep(50 * time.Millisecond)
+		go lock(txn2, 30)
+	}
+	// the other txn commits before the next test changes the config
+	done.Wait()
+	return <-victims
+}
+
+func Test_DeadlockModes(t *testing.T) {
+	defer func(mode string) {
+		config.DeadlockMode = mode
+	}(config.DeadlockMode)
+
+	for _, mode := range []string{locks.Detect, locks.WaitDie, locks.WoundWait} {
+		for _, reversed := range []bool{false, true} {
+			config.DeadlockMode = mode
+			engine := NewUint64Engine().Run()
+			txn1, txn2 := engine.NewTxn(), engine.NewTxn()
+
+			start := time.Now()
+			victim := deadlock(engine, txn1, txn2, reversed)
+			elapsed := time.Since(start)
+			engine.Stop()
+			if victim.State != txns.Aborted {
+				t.Errorf("%s: Expect the victim aborted, got %v\n", mode, victim.State)
+			}
+			// the deadlock is broken as the txn closing the cycle waits, without the sweep
+			if elapsed >= config.DeadlockDetectInterval {
+				t.Errorf("%s: Expect no detection latency, got %v\n", mode, elapsed)
+			}
+			if mode == locks.Detect {
+				continue
+			}
+
+			// the younger txn is aborted at once without detection
+			if victim != txn2 {
+				t.Errorf("%s: Expect the younger txn %d aborted, got %d\n", mode, txn2.ID, victim.ID)
+			}
+		}
+	}
+}
+
+func Test_VictimPolicy(t *testing.T) {
+	defer func(mode string, policy string) {
+		config.DeadlockMode = mode
+		config.DeadlockVictimPolicy = policy
+	}(config.DeadlockMode, config.DeadlockVictimPolicy)
+	config.DeadlockMode = locks.Detect
+
+	config.DeadlockVictimPolicy = "youngest"
+	engine := NewUint64Engine().Run()
+	defer engine.Stop()
+	txn1, txn2 := engine.NewTxn(), engine.NewTxn()
+	if victim := deadlock(engine, txn1, txn2, false); victim != txn2 {
+		t.Errorf("Expect the youngest txn %d aborted, got %d\n", txn2.ID, victim.ID)
+	}
+
+	config.DeadlockVictimPolicy = "lowest-priority"
+	engine = NewUint64Engine().Run()
+	defer engine.Stop()
+	txn1, txn2 = engine.NewTxn(), engine.NewTxn()
+	txn1.Priority, txn2.Priority = 1, 2
+	if victim := deadlock(engine, txn1, txn2, false); victim != txn1 {
+		t.Errorf("Expect the txn %d with the lowest priority aborted, got %d\n", txn1.ID, victim.ID)
+	}
+
+	config.DeadlockVictimPolicy = "smallest-write-set"
+	engine = NewUint64Engine().Run()
+	defer engine.Stop()
+	txn1, txn2 = engine.NewTxn(), engine.NewTxn()
+	_ = engine.Put(txn2, 50, "52")
+	if victim := deadlock(engine, txn1, txn2, false); victim != txn1 {
+		t.Errorf("Expect the txn %d with the smallest write set aborted, got %d\n", txn1.ID, victim.ID)
+	}
+}
+
+func Test_LockTimeout(t *testing.T) {
+	engine := NewUint64Engine().Run()
+	defer engine.Stop()
+
+	txn1 := engine.NewTxn()
+	_ = engine.Put(txn1, 30, "31")
+
+	txn2 := engine.NewTxn()
+	txn2.LockTimeout = txns.NoWait
+	start := time.Now()
+	if err := engine.Put(txn2, 30, "32"); !errs.Is(err, errs.LockTimeout) {
+		t.Errorf("Expect %v, got %v\n", errs.LockTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expect failing at once with NOWAIT, got %v\n", elapsed)
+	}
+
+	txn3 := engine.NewTxn()
+	txn3.LockTimeout = 100 * time.Millisecond
+	start = time.Now()
+	if err := engine.Put(txn3, 30, "33"); !errs.Is(err, errs.LockTimeout) {
+		t.Errorf("Expect %v, got %v\n", errs.LockTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed < txn3.LockTimeout {
+		t.Errorf("Expect waiting for %v, got %v\n", txn3.LockTimeout, elapsed)
+	}
+
+	// the txns timed out are still active, and the ones left the queue
+	_ = txn1.Commit()
+	if err := engine.Put(txn3, 30, "33"); err != nil {
+		t.Errorf("Expect nil, got %v\n", err)
+	}
+	_ = txn3.Commit()
+	_ = txn2.Abort()
+}
+
 func Test_Vacuum(t *testing.T) {
 	const scale = 1000
 	engine := NewUint64Engine().Run()
+	defer engine.Stop()
 
 	for i := 0; i < scale; i++ {
 		txn := engine.NewTxn()
@@ -126,6 +267,7 @@ func Test_Vacuum(t *testing.T) {
 func Test_Concurrency(t *testing.T) {
 	const scale = 250000
 	engine := NewUint64Engine().Run()
+	defer engine.Stop()
 
 	done := sync.WaitGroup{}
 	done.Add(scale - 1)
@@ -146,7 +288,7 @@ func Test_Concurrency(t *testing.T) {
 	txn := engine.NewTxn()
 	defer txn.Commit()
 	for i := 1; i < scale; i++ {
-		val, err := engine.Get(txn, uint64(i))
+		val, _, err := engine.Get(txn, uint64(i))
 		if err != nil {
 			t.Error(err)
 		} else if val != strconv.Itoa(i) {
@@ -158,6 +300,7 @@ func Test_Concurrency(t *testing.T) {
 func Test_StringEngine_Concurrency(t *testing.T) {
 	const scale = 150000
 	engine := NewStringEngine().Run()
+	defer engine.Stop()
 
 	done := sync.WaitGroup{}
 	done.Add(scale - 1)
@@ -180,7 +323,7 @@ func Test_StringEngine_Concurrency(t *testing.T) {
 	defer txn.Commit()
 	for i := 1; i < scale; i++ {
 		key := strconv.Itoa(i)
-		val, err := engine.Get(txn, key)
+		val, _, err := engine.Get(txn, key)
 		if err != nil {
 			t.Error(err)
 		} else if val != key {
@@ -191,6 +334,7 @@ func Test_StringEngine_Concurrency(t *testing.T) {
 
 func Test_DirtyWrite(t *testing.T) {
 	engine := NewStringEngine().Run()
+	defer engine.Stop()
 
 	done := &sync.WaitGroup{}
 	done.Add(2)
@@ -226,7 +370,7 @@ func Test_DirtyWrite(t *testing.T) {
 
 	txn := engine.NewTxn()
 	defer txn.Commit()
-	val, err := engine.Get(txn, "A")
+	val, _, err := engine.Get(txn, "A")
 	if err != nil {
 		t.Error(err)
 	}
@@ -238,6 +382,7 @@ func Test_DirtyWrite(t *testing.T) {
 
 func Test_DirtyRead(t *testing.T) {
 	engine := NewStringEngine().Run()
+	defer engine.Stop()
 
 	done := &sync.WaitGroup{}
 	done.Add(2)
@@ -256,9 +401,9 @@ func Test_DirtyRead(t *testing.T) {
 	})
 
 	t2.Do(func() bool {
-		val, err := engine.Get(txn2, "A")
-		if err == nil {
-			t.Fatalf("Expect err, but got val=%v", val)
+		val, found, err := engine.Get(txn2, "A")
+		if err != nil || found {
+			t.Fatalf("Expect not found, but got val=%v (err=%v)", val, err)
 		}
 
 		txn2.Commit()
@@ -274,6 +419,7 @@ func Test_DirtyRead(t *testing.T) {
 
 func Test_LostUpdate(t *testing.T) {
 	engine := NewStringEngine().Run()
+	defer engine.Stop()
 
 	txn := engine.NewTxn()
 	_ = engine.Put(txn, "A", "Null")
@@ -290,7 +436,7 @@ func Test_LostUpdate(t *testing.T) {
 	var tmp string
 	t1.Do(func() bool {
 		var err error
-		tmp, err = engine.Get(txn1, "A")
+		tmp, _, err = engine.Get(txn1, "A")
 		if err != nil {
 			t.Error(err)
 		}
@@ -298,7 +444,7 @@ func Test_LostUpdate(t *testing.T) {
 	})
 
 	t2.Do(func() bool {
-		val, err := engine.Get(txn2, "A")
+		val, _, err := engine.Get(txn2, "A")
 		if err != nil {
 			t.Error(err)
 		}
@@ -325,7 +471,7 @@ func Test_LostUpdate(t *testing.T) {
 
 	txn = engine.NewTxn()
 	defer txn.Commit()
-	val, err := engine.Get(txn, "A")
+	val, _, err := engine.Get(txn, "A")
 	if err != nil {
 		t.Error(err)
 	}
@@ -339,6 +485,7 @@ func Test_LostUpdate(t *testing.T) {
 
 func Test_NonrepeatableRead(t *testing.T) {
 	engine := NewStringEngine().Run()
+	defer engine.Stop()
 
 	txn := engine.NewTxn()
 	_ = engine.Put(txn, "A", "Null")
@@ -353,7 +500,7 @@ func Test_NonrepeatableRead(t *testing.T) {
 	txn1 := engine.NewTxn()
 	txn2 := engine.NewTxn()
 	t1.Do(func() bool {
-		val, err := engine.Get(txn1, "A")
+		val, _, err := engine.Get(txn1, "A")
 		if err != nil {
 			t.Error(err)
 		}
@@ -373,7 +520,7 @@ func Test_NonrepeatableRead(t *testing.T) {
 	})
 
 	t1.Do(func() bool {
-		val, err := engine.Get(txn1, "A")
+		val, _, err := engine.Get(txn1, "A")
 		if err != nil {
 			t.Error(err)
 		}
@@ -387,7 +534,7 @@ func Test_NonrepeatableRead(t *testing.T) {
 
 	txn = engine.NewTxn()
 	defer txn.Commit()
-	val, _ := engine.Get(txn, "A")
+	val, _, _ := engine.Get(txn, "A")
 	if val != "txn2" {
 		t.Fatalf("Expect txn2, got %s\n", val)
 	}
@@ -395,6 +542,7 @@ func Test_NonrepeatableRead(t *testing.T) {
 
 func Test_ReadSkew(t *testing.T) {
 	engine := NewStringEngine().Run()
+	defer engine.Stop()
 
 	txn := engine.NewTxn()
 	_ = engine.Put(txn, "A", "5")
@@ -410,7 +558,7 @@ func Test_ReadSkew(t *testing.T) {
 	txn1 := engine.NewTxn()
 	txn2 := engine.NewTxn()
 	t1.Do(func() bool {
-		val, err := engine.Get(txn1, "A")
+		val, _, err := engine.Get(txn1, "A")
 		if err != nil {
 			t.Error(err)
 		}
@@ -434,7 +582,7 @@ func Test_ReadSkew(t *testing.T) {
 	})
 
 	t1.Do(func() bool {
-		val, err := engine.Get(txn1, "B")
+		val, _, err := engine.Get(txn1, "B")
 		if err != nil {
 			t.Error(err)
 		}
@@ -448,13 +596,109 @@ func Test_ReadSkew(t *testing.T) {
 
 	txn = engine.NewTxn()
 	defer txn.Commit()
-	A, _ := engine.Get(txn, "A")
+	A, _, _ := engine.Get(txn, "A")
 	if A != "0" {
 		t.Fatalf("Expect 0, got %s\n", A)
 	}
 
-	B, _ := engine.Get(txn, "B")
+	B, _, _ := engine.Get(txn, "B")
 	if B != "10" {
 		t.Fatalf("Expect 10, got %s\n", B)
 	}
 }
+
+func Test_StringEngine_Scan(t *testing.T) {
+	engine := NewStringEngine().Run()
+	defer engine.Stop()
+
+	txn := engine.NewTxn()
+	for _, key := range []string{"user:2", "user:10", "user:1", "admin", "user:1:profile"} {
+		_ = engine.Put(txn, key, key)
+	}
+	txn.Commit()
+
+	txn = engine.NewTxn()
+	defer txn.Commit()
+	res, err := engine.Scan(txn, "user:1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"user:1", "user:10", "user:1:profile"}
+	if len(res) != len(expect) {
+		t.Fatalf("Expect %d, got %d\n", len(expect), len(res))
+	}
+	for i := range expect {
+		if res[i].Key != expect[i] || res[i].Val != expect[i] {
+			t.Fatalf("Expect %s, got %v\n", expect[i], res[i])
+		}
+	}
+}
+
+func Test_StringEngine_Range(t *testing.T) {
+	engine := NewStringEngine().Run()
+	defer engine.Stop()
+
+	txn := engine.NewTxn()
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		_ = engine.Put(txn, key, key)
+	}
+	txn.Commit()
+
+	txn = engine.NewTxn()
+	_ = engine.Del(txn, "c")
+	txn.Commit()
+
+	txn = engine.NewTxn()
+	defer txn.Commit()
+	cases := []struct {
+		start, end string
+		limit      int
+		reverse    bool
+		expect     string
+	}{
+		{"b", "e", 0, false, "bd"},
+		{"b", "e", 0, true, "db"},
+		{"a", "", 2, true, "ed"},
+		{"", "", 3, false, "abd"},
+	}
+	for _, c := range cases {
+		res, err := engine.Range(txn, c.start, c.end, c.limit, c.reverse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for _, pair := range res {
+			got += pair.Key
+		}
+		if got != c.expect {
+			t.Errorf("Expect %s, got %s\n", c.expect, got)
+		}
+	}
+}
+
+func Test_StringEngine_ScanPrefix(t *testing.T) {
+	engine := NewStringEngine().Run()
+	defer engine.Stop()
+
+	txn := engine.NewTxn()
+	for _, key := range []string{"user:12:profile", "user:123:name", "user:123:profile", "user:124:name", "user:123"} {
+		_ = engine.Put(txn, key, key)
+	}
+	txn.Commit()
+
+	txn = engine.NewTxn()
+	defer txn.Commit()
+	res, err := engine.ScanPrefix(txn, "user:123:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || res[0].Key != "user:123:name" || res[1].Key != "user:123:profile" {
+		t.Fatalf("Expect [user:123:name user:123:profile], got %v\n", res)
+	}
+
+	res, _ = engine.ScanPrefix(txn, "user:12", 2)
+	if len(res) != 2 || res[0].Key != "user:123" || res[1].Key != "user:123:name" {
+		t.Fatalf("Expect [user:123 user:123:name], got %v\n", res)
+	}
+}
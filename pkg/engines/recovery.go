@@ -0,0 +1,153 @@
+package engines
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simple-kv/pkg/checkpoint"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/logger"
+	"simple-kv/pkg/values"
+	"simple-kv/pkg/wal"
+	"sort"
+)
+
+type RecoveryStats struct {
+	Checkpoint  uint64
+	Txns        int
+	Keys        int
+	MaxCommitID uint64
+}
+
+// OpenStringEngine rebuilds the engine from the newest checkpoint and the log in
+// `dataDir`, and logs the committed transactions there from now on
+func OpenStringEngine(dataDir string) (*StringEngine, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := checkLayout(dataDir); err != nil {
+		return nil, err
+	}
+	if err := checkpoint.RemoveTemp(dataDir); err != nil {
+		return nil, err
+	}
+
+	engine := NewStringEngine()
+	image, err := checkpoint.LoadNewest(dataDir, engine.Index, config.RecoveryWorkers)
+	if errors.Is(err, checkpoint.ErrUnsupportedVersion) {
+		return nil, incompatible(dataDir, err)
+	} else if err != nil {
+		return nil, err
+	}
+	records, err := wal.RecoverAll(dataDir)
+	if errors.Is(err, wal.ErrUnsupportedVersion) {
+		return nil, incompatible(dataDir, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	stats := engine.Restore(image, records)
+	logger.Inst.Infow("recovery done",
+		"dataDir", dataDir,
+		"checkpoint", stats.Checkpoint,
+		"txns", stats.Txns,
+		"keys", stats.Keys,
+		"maxCommitID", stats.MaxCommitID)
+
+	engine.Log, err = wal.Open(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	engine.TxnManager.SetWAL(engine.Log)
+	engine.Checkpointer = checkpoint.NewCheckpointer(dataDir, engine.Index, engine.TxnManager, engine.Log)
+	return engine, nil
+}
+
+// checkLayout rejects the single log file written before the log was split into
+// segments, which would be ignored otherwise
+func checkLayout(dataDir string) error {
+	path := filepath.Join(dataDir, wal.LegacyFileName)
+	if _, err := os.Stat(path); err == nil {
+		return incompatible(dataDir, fmt.Errorf("found legacy log %s", path))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// incompatible explains what to do with the data written by an older version.
+// Those formats stored the hashes of the keys instead of the keys, so they can
+// not be converted.
+func incompatible(dataDir string, err error) error {
+	return fmt.Errorf("%w: data dir %s was written by an older version storing the hashes of keys, "+
+		"which can not be converted, start with an empty data dir and load the data again", err, dataDir)
+}
+
+func OpenUint64Engine(dataDir string) (*Uint64Engine, error) {
+	engine, err := OpenStringEngine(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Uint64Engine{engine}, nil
+}
+
+// Restore replays the committed records after the loaded checkpoint `image` if any
+// in CommitID order, and restores TxnCounter above the highest CommitID seen.
+// It should be called before any transaction begins.
+func (e *StringEngine) Restore(image *checkpoint.Image, records []*wal.Record) *RecoveryStats {
+	stats := &RecoveryStats{}
+	if image != nil {
+		stats.Checkpoint = image.Timestamp
+		stats.MaxCommitID = image.Timestamp
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CommitID < records[j].CommitID
+	})
+
+	newest := map[string]*wal.Entry{}
+	commitIDs := map[string]uint64{}
+	for _, record := range records {
+		// the segments covered by the checkpoint may be left by a crash
+		if record.CommitID <= stats.Checkpoint {
+			continue
+		}
+
+		for _, entry := range record.Entries {
+			newest[entry.Key] = entry
+			commitIDs[entry.Key] = record.CommitID
+		}
+		stats.MaxCommitID = record.CommitID
+		stats.Txns++
+	}
+
+	for key, entry := range newest {
+		// the whole chain before a tombstone is invisible to any new transaction
+		if entry.Deleted {
+			if val := e.Index.Get(key); val != nil {
+				e.Index.Vacuum(key)
+				e.Index.ValueManager.DelValue(val.ID)
+			}
+			continue
+		}
+		e.install(key, entry.Val, commitIDs[key])
+	}
+
+	e.Index.Walk(func(_ string, _ *values.Value) bool {
+		stats.Keys++
+		return true
+	})
+
+	if stats.MaxCommitID > e.TxnManager.TxnCounter {
+		e.TxnManager.TxnCounter = stats.MaxCommitID
+	}
+	return stats
+}
+
+func (e *StringEngine) install(key string, val string, commitID uint64) {
+	version := values.NewVersion(val)
+	version.Install(commitID)
+	e.Index.MustGet(key, val).VersionHeader = version
+}
@@ -0,0 +1,222 @@
+package engines
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"simple-kv/pkg/checkpoint"
+	"simple-kv/pkg/wal"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_Recovery(t *testing.T) {
+	dir := t.TempDir()
+	engine, err := OpenUint64Engine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 1; i <= 100; i++ {
+		txn := engine.NewTxn()
+		_ = engine.Put(txn, uint64(i), strconv.Itoa(i))
+		if err = txn.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	txn := engine.NewTxn()
+	_ = engine.Put(txn, 1, "updated")
+	_ = engine.Del(txn, 2)
+	_ = txn.Commit()
+	commitID := txn.CommitID
+
+	// aborted and unfinished transactions are never logged
+	txn = engine.NewTxn()
+	_ = engine.Put(txn, 3, "aborted")
+	_ = txn.Abort()
+	txn = engine.NewTxn()
+	_ = engine.Put(txn, 4, "unfinished")
+	_ = engine.Close()
+
+	engine, err = OpenUint64Engine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+
+	if engine.TxnManager.TxnCounter < commitID {
+		t.Errorf("Expect counter >= %d, got %d\n", commitID, engine.TxnManager.TxnCounter)
+	}
+
+	txn = engine.NewTxn()
+	defer txn.Commit()
+	expect := map[uint64]string{1: "updated", 3: "3", 4: "4", 100: "100"}
+	for key, val := range expect {
+		got, _, err := engine.Get(txn, key)
+		if err != nil || got != val {
+			t.Errorf("Expect %s, got %s (err=%v)\n", val, got, err)
+		}
+	}
+	if got, found, err := engine.Get(txn, 2); err != nil || found {
+		t.Errorf("Expect not found, got %s (err=%v)\n", got, err)
+	}
+}
+
+func Test_Recovery_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	engine, err := OpenUint64Engine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 10; i++ {
+		txn := engine.NewTxn()
+		_ = engine.Put(txn, uint64(i), strconv.Itoa(i))
+		_ = txn.Commit()
+	}
+	_ = engine.Close()
+
+	path := wal.SegmentPath(dir, 1)
+	stat, _ := os.Stat(path)
+	_ = os.Truncate(path, stat.Size()-1)
+
+	engine, err = OpenUint64Engine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn := engine.NewTxn()
+	_ = engine.Put(txn, 10, "again")
+	_ = txn.Commit()
+	_ = engine.Close()
+
+	engine, err = OpenUint64Engine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+
+	txn = engine.NewTxn()
+	defer txn.Commit()
+	if val, _, err := engine.Get(txn, 9); val != "9" {
+		t.Errorf("Expect 9, got %s (err=%v)\n", val, err)
+	}
+	if val, _, err := engine.Get(txn, 10); val != "again" {
+		t.Errorf("Expect again, got %s (err=%v)\n", val, err)
+	}
+}
+
+func Test_Recovery_Checkpoint(t *testing.T) {
+	const scale = 1000
+
+	dir := t.TempDir()
+	engine, err := OpenUint64Engine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Run()
+	defer engine.Stop()
+
+	for i := 1; i <= scale; i++ {
+		txn := engine.NewTxn()
+		_ = engine.Put(txn, uint64(i), strconv.Itoa(i))
+		_ = txn.Commit()
+	}
+
+	// writers keep going during the checkpoint
+	done := sync.WaitGroup{}
+	done.Add(1)
+	go func() {
+		for i := 1; i <= scale; i++ {
+			txn := engine.NewTxn()
+			_ = engine.Put(txn, uint64(i), "new"+strconv.Itoa(i))
+			_ = txn.Commit()
+		}
+		done.Done()
+	}()
+	if err = engine.Checkpointer.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	done.Wait()
+
+	txn := engine.NewTxn()
+	_ = engine.Del(txn, 1)
+	_ = txn.Commit()
+	// the checkpointer should not run after the log is closed
+	engine.Stop()
+	_ = engine.Close()
+
+	if timestamps, _ := checkpoint.List(dir); len(timestamps) != 1 {
+		t.Errorf("Expect 1 checkpoint, got %v\n", timestamps)
+	}
+	if seqs, _ := wal.Segments(dir); len(seqs) != 1 || seqs[0] != 2 {
+		t.Errorf("Expect segments [2], got %v\n", seqs)
+	}
+
+	engine, err = OpenUint64Engine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+
+	txn = engine.NewTxn()
+	defer txn.Commit()
+	if val, found, err := engine.Get(txn, 1); err != nil || found {
+		t.Errorf("Expect not found, got %s (err=%v)\n", val, err)
+	}
+	for i := 2; i <= scale; i++ {
+		val, _, err := engine.Get(txn, uint64(i))
+		if err != nil || val != "new"+strconv.Itoa(i) {
+			t.Fatalf("Expect new%d, got %s (err=%v)\n", i, val, err)
+		}
+	}
+}
+
+func Test_Recovery_Legacy(t *testing.T) {
+	walHeader := make([]byte, wal.FileHeaderLength)
+	binary.BigEndian.PutUint32(walHeader, wal.Magic)
+	binary.BigEndian.PutUint16(walHeader[4:], 1)
+	ckptHeader := make([]byte, checkpoint.FileHeaderLength)
+	binary.BigEndian.PutUint32(ckptHeader, checkpoint.Magic)
+	binary.BigEndian.PutUint16(ckptHeader[4:], 2)
+
+	// the data of older versions is rejected, not skipped
+	for _, file := range []struct {
+		name   string
+		header []byte
+	}{
+		{wal.LegacyFileName, walHeader},
+		{filepath.Base(wal.SegmentPath("", 1)), walHeader},
+		{filepath.Base(checkpoint.FilePath("", 1)), ckptHeader},
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, file.name), file.header, 0644); err != nil {
+			t.Fatal(err)
+		}
+		engine, err := OpenUint64Engine(dir)
+		if err == nil {
+			_ = engine.Close()
+			t.Fatalf("Expect error for %s, got nil\n", file.name)
+		}
+		if !strings.Contains(err.Error(), "empty data dir") {
+			t.Errorf("Expect an actionable error for %s, got %v\n", file.name, err)
+		}
+	}
+
+	// the checkpoint left unfinished by a crash is removed
+	dir := t.TempDir()
+	temp := checkpoint.FilePath(dir, 1) + checkpoint.TempSuffix
+	if err := os.WriteFile(temp, ckptHeader, 0644); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := OpenUint64Engine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = engine.Close()
+	if _, err = os.Stat(temp); !os.IsNotExist(err) {
+		t.Errorf("Expect %s removed, got %v\n", temp, err)
+	}
+}
@@ -1,51 +1,177 @@
 package engines
 
 import (
-	"github.com/cespare/xxhash"
+	"simple-kv/pkg/checkpoint"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/gc"
+	"simple-kv/pkg/index"
+	"simple-kv/pkg/locks"
+	"simple-kv/pkg/locks/manager"
 	"simple-kv/pkg/txns"
+	txnmanager "simple-kv/pkg/txns/manager"
 	"simple-kv/pkg/values"
+	valuemanager "simple-kv/pkg/values/manager"
+	"simple-kv/pkg/wal"
 )
 
+type Pair struct {
+	Key string
+	Val string
+}
+
+// StringEngine keeps records in the index by their key bytes, so keys never
+// collide and records are scanned in lexicographic order
 type StringEngine struct {
-	uint64Engine *Uint64Engine
+	Index        *index.SkipList
+	Collector    *gc.GarbageCollector
+	Detector     *manager.DeadlockDetector
+	TxnManager   *txnmanager.TxnManager
+	Log          *wal.Log
+	Checkpointer *checkpoint.Checkpointer
 }
 
 func NewStringEngine() *StringEngine {
-	return &StringEngine{NewUint64Engine()}
-}
+	valueManager := valuemanager.NewValueManager()
+	lockManager := manager.NewLockManager()
+	txnManager := txnmanager.NewTxnManager(valueManager)
+	collector := gc.NewGarbageCollector(txnManager, valueManager)
+	detector := manager.NewDeadlockDetector(txnManager, valueManager, lockManager)
+	txnManager.SetGC(collector)
 
-func hash(key string) uint64 {
-	h := xxhash.Sum64String(key)
-	if h == 0 {
-		h = 1
+	return &StringEngine{
+		Index:      index.NewSkipList(valueManager, lockManager),
+		Collector:  collector,
+		Detector:   detector,
+		TxnManager: txnManager,
 	}
-	return h
 }
 
+// Run starts the background workers: GC, deadlock detection unless deadlocks
+// are prevented, and checkpointing if the engine is opened with a data directory
 func (e *StringEngine) Run() *StringEngine {
-	e.uint64Engine.Run()
+	go e.Collector.Run()
+	if config.DeadlockMode == locks.Detect {
+		go e.Detector.Run()
+	}
+	if e.Checkpointer != nil {
+		go e.Checkpointer.Run()
+	}
 	return e
 }
 
+// Stop stops the background workers and waits for their running turns
+func (e *StringEngine) Stop() {
+	e.Collector.Stop()
+	e.Detector.Stop()
+	if e.Checkpointer != nil {
+		e.Checkpointer.Stop()
+	}
+}
+
+func (e *StringEngine) Close() error {
+	if e.Log == nil {
+		return nil
+	}
+	return e.Log.Close()
+}
+
 func (e *StringEngine) NewTxn() *txns.Txn {
-	return e.uint64Engine.NewTxn()
+	return e.TxnManager.NewTxn()
 }
 
-func (e *StringEngine) Get(txn *txns.Txn, key string) (string, error) {
-	return e.uint64Engine.Get(txn, hash(key))
+// GetVersion returns nil if there is no version of the key visible to the txn
+func (e *StringEngine) GetVersion(txn *txns.Txn, key string) (*values.Version, error) {
+	if txn.State != txns.Processing {
+		return nil, errs.New(errs.TxnNotActive, "transaction has been done: status=%v", txn.State)
+	}
+	val := e.Index.Get(key)
+	if val == nil {
+		return nil, nil
+	}
+
+	return val.Traverse(txn)
+}
+
+// Get returns found=false if the key does not exist or is deleted for the txn
+func (e *StringEngine) Get(txn *txns.Txn, key string) (val string, found bool, err error) {
+	version, err := e.GetVersion(txn, key)
+	if err != nil || version == nil {
+		return "", false, err
+	}
+	return version.Val, true, nil
 }
 
 func (e *StringEngine) Put(txn *txns.Txn, key string, value string) error {
-	return e.uint64Engine.Put(txn, hash(key), value)
+	if txn.State != txns.Processing {
+		return errs.New(errs.TxnNotActive, "transaction has been done: status=%v", txn.State)
+	}
+	val := e.Index.MustGet(key, value)
+	if val == nil {
+		return errs.New(errs.InvalidArgument, "invalid key: %q", key)
+	}
+
+	writing, err := val.Put(txn, value)
+	if err != nil {
+		return err
+	}
+
+	if writing {
+		txn.SetWriting(val.ID, key, e.Index.ID)
+	}
+	return nil
 }
 
 func (e *StringEngine) Del(txn *txns.Txn, key string) error {
-	return e.uint64Engine.Del(txn, hash(key))
+	if txn.State != txns.Processing {
+		return errs.New(errs.TxnNotActive, "transaction has been done: status=%v", txn.State)
+	}
+	val := e.Index.Get(key)
+	if val == nil {
+		return nil
+	}
+
+	writing, err := val.Del(txn)
+	if writing {
+		txn.SetWriting(val.ID, key, e.Index.ID)
+	}
+	return err
 }
 
-func (e *StringEngine) Scan(txn *txns.Txn, key string, count int) (res []string, err error) {
-	return e.uint64Engine.Scan(txn, hash(key), count)
+// Scan query `count` records sequentially from the one with key >= `key` in lexicographic order
+func (e *StringEngine) Scan(txn *txns.Txn, key string, count int) (res []*Pair, err error) {
+	if count <= 0 {
+		return nil, nil
+	}
+	return e.Range(txn, key, "", count, false)
 }
-func (e *StringEngine) GetVersion(txn *txns.Txn, key string) (*values.Version, error) {
-	return e.uint64Engine.GetVersion(txn, hash(key))
+
+// ScanPrefix query at most `count` records with key prefixed by `prefix` in lexicographic order,
+// a `count` <= 0 is unlimited
+func (e *StringEngine) ScanPrefix(txn *txns.Txn, prefix string, count int) (res []*Pair, err error) {
+	return e.Range(txn, prefix, index.PrefixEnd(prefix), count, false)
+}
+
+// Range query at most `limit` records with key in [`start`, `end`) in lexicographic order,
+// or in reverse order if `reverse`. An empty `end` is unbounded, and a `limit` <= 0 is unlimited.
+func (e *StringEngine) Range(txn *txns.Txn, start string, end string, limit int, reverse bool) (res []*Pair, err error) {
+	if txn.State != txns.Processing {
+		return nil, errs.New(errs.TxnNotActive, "transaction has been done: status=%v", txn.State)
+	}
+
+	e.Index.Range(start, end, reverse, func(key string, val *values.Value) bool {
+		var version *values.Version
+		version, err = val.Traverse(txn)
+		if err != nil {
+			return false
+		}
+		if version != nil {
+			res = append(res, &Pair{Key: key, Val: version.Val})
+		}
+		return limit <= 0 || len(res) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
 }
@@ -1,120 +1,53 @@
 package engines
 
 import (
-	"fmt"
-	"simple-kv/pkg/gc"
-	"simple-kv/pkg/index"
-	"simple-kv/pkg/locks/manager"
+	"encoding/binary"
 	"simple-kv/pkg/txns"
-	txnmanager "simple-kv/pkg/txns/manager"
 	"simple-kv/pkg/values"
-	valuemanager "simple-kv/pkg/values/manager"
 )
 
+// Uint64Engine keys records by the big-endian bytes of uint64, which keeps the numeric order
 type Uint64Engine struct {
-	Index      *index.SkipList
-	Collector  *gc.GarbageCollector
-	Detector   *manager.DeadlockDetector
-	TxnManager *txnmanager.TxnManager
+	*StringEngine
 }
 
 func NewUint64Engine() *Uint64Engine {
-	valueManager := valuemanager.NewValueManager()
-	lockManager := manager.NewLockManager()
-	txnManager := txnmanager.NewTxnManager(valueManager)
-	collector := gc.NewGarbageCollector(txnManager, valueManager)
-	detector := manager.NewDeadlockDetector(txnManager, valueManager, lockManager)
-	txnManager.SetGC(collector)
+	return &Uint64Engine{NewStringEngine()}
+}
+
+func EncodeUint64(key uint64) string {
+	buffer := make([]byte, 8)
+	binary.BigEndian.PutUint64(buffer, key)
+	return string(buffer)
+}
 
-	return &Uint64Engine{
-		Index:      index.NewSkipList(valueManager, lockManager),
-		Collector:  collector,
-		Detector:   detector,
-		TxnManager: txnManager,
-	}
+func DecodeUint64(key string) uint64 {
+	return binary.BigEndian.Uint64([]byte(key))
 }
 
 func (e *Uint64Engine) Run() *Uint64Engine {
-	go e.Collector.Run()
-	go e.Detector.Run()
+	e.StringEngine.Run()
 	return e
 }
 
 func (e *Uint64Engine) GetVersion(txn *txns.Txn, key uint64) (*values.Version, error) {
-	if txn.State != txns.Processing {
-		return nil, fmt.Errorf("transaction has been done: status=%v", txn.State)
-	}
-	val := e.Index.Get(key)
-	if val == nil {
-		return nil, fmt.Errorf("no such key: %d", key)
-	}
-
-	return val.Traverse(txn)
+	return e.StringEngine.GetVersion(txn, EncodeUint64(key))
 }
 
-func (e *Uint64Engine) Get(txn *txns.Txn, key uint64) (string, error) {
-	if txn.State != txns.Processing {
-		return "", fmt.Errorf("transaction has been done: status=%v", txn.State)
-	}
-	val := e.Index.Get(key)
-	if val == nil {
-		return "", fmt.Errorf("no such key: %d", key)
-	}
-
-	version, err := val.Traverse(txn)
-	if version == nil {
-		return "", fmt.Errorf("no such key: %d", key)
-	}
-	return version.Val, err
+func (e *Uint64Engine) Get(txn *txns.Txn, key uint64) (string, bool, error) {
+	return e.StringEngine.Get(txn, EncodeUint64(key))
 }
 
 func (e *Uint64Engine) Put(txn *txns.Txn, key uint64, value string) error {
-	if txn.State != txns.Processing {
-		return fmt.Errorf("transaction has been done: status=%v", txn.State)
-	}
-	val := e.Index.MustGet(key, value)
-	writing, err := val.Put(txn, value)
-	if err != nil {
-		return err
-	}
-
-	if writing {
-		txn.SetWriting(val.ID, key, e.Index.ID)
-	}
-	return nil
+	return e.StringEngine.Put(txn, EncodeUint64(key), value)
 }
 
 func (e *Uint64Engine) Del(txn *txns.Txn, key uint64) error {
-	if txn.State != txns.Processing {
-		return fmt.Errorf("transaction has been done: status=%v", txn.State)
-	}
-	val := e.Index.Get(key)
-	if val == nil {
-		return nil
-	}
-
-	writing, err := val.Del(txn)
-	if writing {
-		txn.SetWriting(val.ID, key, e.Index.ID)
-	}
-	return err
-}
-
-// Scan query `count` records sequentially from the one with key >= `key`
-func (e *Uint64Engine) Scan(txn *txns.Txn, key uint64, count int) (res []string, err error) {
-	if txn.State != txns.Processing {
-		return nil, fmt.Errorf("transaction has been done: status=%v", txn.State)
-	}
-	for _, val := range e.Index.Scan(key, count) {
-		version, err := val.Traverse(txn)
-		if err != nil {
-			return nil, err
-		}
-		res = append(res, version.Val)
-	}
-	return
+	return e.StringEngine.Del(txn, EncodeUint64(key))
 }
 
-func (e *Uint64Engine) NewTxn() *txns.Txn {
-	return e.TxnManager.NewTxn()
+// Scan query `count` records sequentially from the one with key >= `key`,
+// the keys of result are encoded, see DecodeUint64
+func (e *Uint64Engine) Scan(txn *txns.Txn, key uint64, count int) (res []*Pair, err error) {
+	return e.StringEngine.Scan(txn, EncodeUint64(key), count)
 }
@@ -0,0 +1,90 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code classifies an error for clients. The values are sent over the wire, so
+// they must never be renumbered.
+type Code uint16
+
+const (
+	Unknown         Code = 0
+	DeadlockVictim  Code = 1
+	LockTimeout     Code = 2
+	NoSuchKey       Code = 3
+	TxnNotActive    Code = 4
+	InvalidArgument Code = 5
+	Protocol        Code = 6
+	ServerBusy      Code = 7
+	Internal        Code = 8
+	TxnActive       Code = 9
+)
+
+var codeNames = map[Code]string{
+	Unknown:         "UNKNOWN",
+	DeadlockVictim:  "DEADLOCK_VICTIM",
+	LockTimeout:     "LOCK_TIMEOUT",
+	NoSuchKey:       "NO_SUCH_KEY",
+	TxnNotActive:    "TXN_NOT_ACTIVE",
+	InvalidArgument: "INVALID_ARGUMENT",
+	Protocol:        "PROTOCOL_ERROR",
+	ServerBusy:      "SERVER_BUSY",
+	Internal:        "INTERNAL",
+	TxnActive:       "TXN_ACTIVE",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("CODE_%d", uint16(c))
+}
+
+// Retryable tells whether the transaction may succeed if it is run again
+func (c Code) Retryable() bool {
+	return c == DeadlockVictim || c == LockTimeout
+}
+
+// Coder is an error with a code, errors of other packages may implement it
+type Coder interface {
+	Code() Code
+}
+
+type Error struct {
+	code    Code
+	Message string
+}
+
+func New(code Code, format string, args ...any) *Error {
+	return &Error{
+		code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Code() Code {
+	return e.code
+}
+
+// CodeOf returns the code of the first error with a code in the chain of `err`
+func CodeOf(err error) Code {
+	if err == nil {
+		return Unknown
+	}
+
+	var coder Coder
+	if errors.As(err, &coder) {
+		return coder.Code()
+	}
+	return Unknown
+}
+
+func Is(err error, code Code) bool {
+	return err != nil && CodeOf(err) == code
+}
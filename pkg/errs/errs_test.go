@@ -0,0 +1,26 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCodeOf(t *testing.T) {
+	err := New(DeadlockVictim, "txn aborted: txn=%d", 1)
+	if CodeOf(err) != DeadlockVictim || err.Error() != "txn aborted: txn=1" {
+		t.Errorf("Expect %v, got %v %v\n", DeadlockVictim, CodeOf(err), err)
+	}
+
+	wrapped := fmt.Errorf("fail to put: %w", err)
+	if !Is(wrapped, DeadlockVictim) || !CodeOf(wrapped).Retryable() {
+		t.Errorf("Expect retryable %v, got %v\n", DeadlockVictim, CodeOf(wrapped))
+	}
+
+	if CodeOf(errors.New("plain")) != Unknown || CodeOf(nil) != Unknown {
+		t.Errorf("Expect %v\n", Unknown)
+	}
+	if Code(100).String() != "CODE_100" || NoSuchKey.String() != "NO_SUCH_KEY" {
+		t.Errorf("Expect names of codes, got %v %v\n", Code(100), NoSuchKey)
+	}
+}
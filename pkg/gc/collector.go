@@ -2,11 +2,12 @@ package gc
 
 import (
 	"math"
+	"simple-kv/pkg/config"
 	"simple-kv/pkg/index"
 	"simple-kv/pkg/modules"
 	"simple-kv/pkg/txns"
 	values2 "simple-kv/pkg/values"
-	"time"
+	"simple-kv/pkg/workers"
 )
 
 type GarbageCollector struct {
@@ -14,6 +15,7 @@ type GarbageCollector struct {
 	NeedCleanTxns []*txns.Txn
 	TxnManager    modules.TxnManager
 	ValueManager  modules.ValueManager
+	loop          *workers.Loop
 }
 
 func NewGarbageCollector(txnManager modules.TxnManager, valueManager modules.ValueManager) *GarbageCollector {
@@ -23,13 +25,17 @@ func NewGarbageCollector(txnManager modules.TxnManager, valueManager modules.Val
 		NeedCleanTxns: []*txns.Txn{},
 		TxnManager:    txnManager,
 		ValueManager:  valueManager,
+		loop:          workers.NewLoop(),
 	}
 }
 
+// Run cleans every config.GCInterval until Stop is called
 func (g *GarbageCollector) Run() {
-	for range time.Tick(time.Millisecond * 50) {
-		g.Clean()
-	}
+	g.loop.Run(config.GCInterval, g.Clean)
+}
+
+func (g *GarbageCollector) Stop() {
+	g.loop.Stop()
 }
 
 func (g *GarbageCollector) Register(txn *txns.Txn) {
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"fmt"
 	"math/rand"
 	"simple-kv/pkg/config"
 	modules2 "simple-kv/pkg/modules"
@@ -16,14 +17,16 @@ var (
 )
 
 type SkipNode struct {
-	Key uint64
+	Key string
 	Val *values.Value
 
 	Nexts []*SkipNode
+	// Prev is the backward link at level 0 for reverse iteration
+	Prev  *SkipNode
 	Level int
 }
 
-func (s *SkipList) NewSkipNode(key uint64, val string) *SkipNode {
+func (s *SkipList) NewSkipNode(key string, val string) *SkipNode {
 	nexts := make([]*SkipNode, config.SkipListMaxLevel)
 	for i := range nexts {
 		nexts[i] = nil
@@ -31,7 +34,7 @@ func (s *SkipList) NewSkipNode(key uint64, val string) *SkipNode {
 
 	return &SkipNode{
 		Key:   key,
-		Val:   s.ValueManager.NewValue(s.LockManager.NewRWLock()),
+		Val:   s.ValueManager.NewValue(s.LockManager.NewRWLock(key)),
 		Nexts: nexts,
 		Level: 0,
 	}
@@ -55,13 +58,14 @@ func NewSkipList(valueManager modules2.ValueManager, lockManager modules2.LockMa
 		LockManager:  lockManager,
 		latch:        sync.Mutex{},
 	}
-	index.Header = index.NewSkipNode(0, "HEADER")
+	index.Header = index.NewSkipNode("", "HEADER")
 	ActiveIndex[index.ID] = index
 	return index
 }
 
-func (s *SkipList) Get(key uint64) *values.Value {
-	if key == 0 {
+// Get finds the record by key, the empty key is reserved for the header
+func (s *SkipList) Get(key string) *values.Value {
+	if key == "" {
 		return nil
 	}
 
@@ -82,8 +86,8 @@ func (s *SkipList) Get(key uint64) *values.Value {
 	return node.Val
 }
 
-func (s *SkipList) MustGet(key uint64, val string) *values.Value {
-	if key == 0 {
+func (s *SkipList) MustGet(key string, val string) *values.Value {
+	if key == "" {
 		return nil
 	}
 
@@ -118,41 +122,144 @@ func (s *SkipList) MustGet(key uint64, val string) *values.Value {
 		newNode.Nexts[i] = updates[i].Nexts[i]
 		updates[i].Nexts[i] = newNode
 	}
+	newNode.Prev = updates[0]
+	if newNode.Nexts[0] != nil {
+		newNode.Nexts[0].Prev = newNode
+	}
 	return newNode.Val
 }
 
-// TODO: need test
-// Scan query `count` records sequentially from the one with key >= `key`
-func (s *SkipList) Scan(key uint64, count int) []*values.Value {
-	if key == 0 {
-		return nil
-	}
-
+// BulkLoad appends the records in ascending key order, which should be greater
+// than all existing keys. Nodes are linked from the tail of every level, so it
+// costs no search per record.
+func (s *SkipList) BulkLoad(keys []string, vals []*values.Value) error {
 	s.latch.Lock()
 	defer s.latch.Unlock()
 
+	tails := make([]*SkipNode, config.SkipListMaxLevel)
 	node := s.Header
-	for i := s.Level; i >= 0; i-- {
-		for node.Nexts[i] != nil && node.Nexts[i].Key < key {
+	for i := config.SkipListMaxLevel - 1; i >= 0; i-- {
+		for i <= s.Level && node.Nexts[i] != nil {
 			node = node.Nexts[i]
 		}
+		tails[i] = node
 	}
 
-	node = node.Nexts[0]
-	var result []*values.Value
-	for node != nil && count > 0 {
-		result = append(result, node.Val)
-		node = node.Nexts[0]
+	for i, key := range keys {
+		if key == "" || (tails[0] != s.Header && key <= tails[0].Key) {
+			return fmt.Errorf("bulk load keys out of order: last=%q, key=%q", tails[0].Key, key)
+		}
+
+		newNode := &SkipNode{
+			Key:   key,
+			Val:   vals[i],
+			Nexts: make([]*SkipNode, config.SkipListMaxLevel),
+			Level: getLevel(),
+		}
+		if newNode.Level > s.Level {
+			s.Level = newNode.Level
+			s.Header.Level = newNode.Level
+		}
+		newNode.Prev = tails[0]
+		for l := 0; l <= newNode.Level; l++ {
+			tails[l].Nexts[l] = newNode
+			tails[l] = newNode
+		}
+	}
+	return nil
+}
+
+// Scan query `count` records sequentially from the one with key >= `key` in lexicographic order
+func (s *SkipList) Scan(key string, count int) []*SkipNode {
+	return s.rangeNodes(key, "", false, count)
+}
+
+// Walk calls `fn` on every record in key order until it returns false
+func (s *SkipList) Walk(fn func(key string, val *values.Value) bool) {
+	s.Range("", "", false, fn)
+}
+
+// Range calls `fn` on the records with key in [`start`, `end`) in key order, or in
+// reverse order if `reverse`, until it returns false. An empty `end` is unbounded.
+// The latch is held only while collecting a batch, so writers are not blocked during the walk.
+func (s *SkipList) Range(start string, end string, reverse bool, fn func(key string, val *values.Value) bool) {
+	const batch = 1024
+
+	for {
+		nodes := s.rangeNodes(start, end, reverse, batch)
+		for _, node := range nodes {
+			if !fn(node.Key, node.Val) {
+				return
+			}
+		}
+
+		if len(nodes) < batch {
+			return
+		}
+		last := nodes[len(nodes)-1].Key
+		if reverse {
+			end = last
+		} else {
+			// the smallest key greater than the last one
+			start = last + "\x00"
+		}
+	}
+}
+
+func (s *SkipList) rangeNodes(start string, end string, reverse bool, count int) []*SkipNode {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+
+	var result []*SkipNode
+	if !reverse {
+		node := s.findLess(start, false).Nexts[0]
+		for node != nil && count > 0 && (end == "" || node.Key < end) {
+			result = append(result, node)
+			node = node.Nexts[0]
+			count--
+		}
+		return result
+	}
+
+	node := s.findLess(end, end == "")
+	for node != s.Header && count > 0 && node.Key >= start {
+		result = append(result, node)
+		node = node.Prev
 		count--
 	}
 	return result
 }
 
-func (s *SkipList) Vacuum(key uint64) bool {
+// PrefixEnd returns the smallest key greater than all keys with `prefix`, which is
+// the exclusive end to range over the prefix. It returns the empty key if unbounded.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// findLess finds the last node with key < `key`, or the last node if `unbounded`.
+// It returns the header if there is no such node.
+func (s *SkipList) findLess(key string, unbounded bool) *SkipNode {
+	node := s.Header
+	for i := s.Level; i >= 0; i-- {
+		for node.Nexts[i] != nil && (unbounded || node.Nexts[i].Key < key) {
+			node = node.Nexts[i]
+		}
+	}
+	return node
+}
+
+func (s *SkipList) Vacuum(key string) bool {
 	s.latch.Lock()
 	defer s.latch.Unlock()
 
-	if key == 0 {
+	if key == "" {
 		return false
 	}
 
@@ -167,26 +274,30 @@ func (s *SkipList) Vacuum(key uint64) bool {
 	}
 
 	node = node.Nexts[0]
-	for i := 0; i <= s.Level; i++ {
-		next := updates[i].Nexts[i]
-		// TODO: if next == nil ?
-		if next != nil && next.Key != key {
+	if node == nil || node.Key != key {
+		return false
+	}
+
+	for i := 0; i <= node.Level; i++ {
+		if updates[i].Nexts[i] != node {
 			break
 		}
-		updates[i].Nexts[i] = next.Nexts[i]
+		updates[i].Nexts[i] = node.Nexts[i]
+	}
+	if node.Nexts[0] != nil {
+		node.Nexts[0].Prev = node.Prev
 	}
 
-	l := node.Level
-	for l > 0 && s.Header.Nexts[l] == nil {
+	for s.Level > 0 && s.Header.Nexts[s.Level] == nil {
 		s.Level--
-		l--
 	}
+	s.Header.Level = s.Level
 	return true
 }
 
 func getLevel() int {
 	level := 0
-	for rand.Float64() < config.SkipListProp && level < config.SkipListMaxLevel {
+	for rand.Float64() < config.SkipListProp && level < config.SkipListMaxLevel-1 {
 		level++
 	}
 	return level
@@ -1,8 +1,11 @@
 package index
 
 import (
+	"fmt"
 	"simple-kv/pkg/locks/manager"
+	"simple-kv/pkg/values"
 	manager2 "simple-kv/pkg/values/manager"
+	"sort"
 	"strconv"
 	"testing"
 )
@@ -11,12 +14,12 @@ func TestSkipList_Put_Basic(t *testing.T) {
 	lockMgr := manager.NewLockManager()
 	valMgr := manager2.NewValueManager()
 	s := NewSkipList(valMgr, lockMgr)
-	s.MustGet(30, "30")
-	s.MustGet(50, "50")
-	s.MustGet(40, "40")
-	s.MustGet(20, "20")
+	s.MustGet("30", "30")
+	s.MustGet("50", "50")
+	s.MustGet("40", "40")
+	s.MustGet("20", "20")
 
-	value := s.Get(20)
+	value := s.Get("20")
 	if value == nil {
 		t.Errorf("Expect non-nil, got nil\n")
 	} else if value.VersionHeader != nil {
@@ -31,11 +34,11 @@ func TestSkipList_Put_Large(t *testing.T) {
 	valMgr := manager2.NewValueManager()
 	s := NewSkipList(valMgr, lockMgr)
 	for i := 1; i < scale; i++ {
-		s.MustGet(uint64(i), strconv.Itoa(i))
+		s.MustGet(strconv.Itoa(i), strconv.Itoa(i))
 	}
 
 	for i := 1; i < scale; i++ {
-		value := s.Get(uint64(i))
+		value := s.Get(strconv.Itoa(i))
 		if value == nil {
 			t.Errorf("Expect non-nil, got nil\n")
 		} else if value.VersionHeader != nil {
@@ -44,11 +47,11 @@ func TestSkipList_Put_Large(t *testing.T) {
 	}
 
 	for i := 1; i < scale; i++ {
-		s.MustGet(uint64(i), strconv.Itoa(i+1))
+		s.MustGet(strconv.Itoa(i), strconv.Itoa(i+1))
 	}
 
 	for i := 1; i < scale; i++ {
-		value := s.Get(uint64(i))
+		value := s.Get(strconv.Itoa(i))
 		if value == nil {
 			t.Errorf("Expect non-nil, got nil\n")
 		} else if value.VersionHeader != nil {
@@ -61,14 +64,14 @@ func TestSkipList_Del_Basic(t *testing.T) {
 	lockMgr := manager.NewLockManager()
 	valMgr := manager2.NewValueManager()
 	s := NewSkipList(valMgr, lockMgr)
-	s.MustGet(30, "30")
-	s.MustGet(50, "50")
-	s.MustGet(40, "40")
-	s.MustGet(20, "20")
+	s.MustGet("30", "30")
+	s.MustGet("50", "50")
+	s.MustGet("40", "40")
+	s.MustGet("20", "20")
 
-	s.Vacuum(20)
+	s.Vacuum("20")
 
-	version := s.Get(20)
+	version := s.Get("20")
 	if version != nil {
 		t.Errorf("Expect nil, got %v\n", version)
 	}
@@ -81,17 +84,101 @@ func TestSkipList_Del_Large(t *testing.T) {
 	valMgr := manager2.NewValueManager()
 	s := NewSkipList(valMgr, lockMgr)
 	for i := 1; i < scale; i++ {
-		s.MustGet(uint64(i), strconv.Itoa(i))
+		s.MustGet(strconv.Itoa(i), strconv.Itoa(i))
 	}
 
 	for i := 1; i < scale; i++ {
-		s.Vacuum(uint64(i))
+		s.Vacuum(strconv.Itoa(i))
 	}
 
 	for i := 1; i < scale; i++ {
-		version := s.Get(uint64(i))
+		version := s.Get(strconv.Itoa(i))
 		if version != nil {
 			t.Errorf("Expect nil, got %v\n", version)
 		}
 	}
 }
+
+func TestSkipList_Scan_Order(t *testing.T) {
+	lockMgr := manager.NewLockManager()
+	valMgr := manager2.NewValueManager()
+	s := NewSkipList(valMgr, lockMgr)
+	for _, key := range []string{"b", "ab", "a", "ba", "c", "b\x00"} {
+		s.MustGet(key, key).VersionHeader = values.NewVersion(key)
+	}
+
+	expect := []string{"b", "b\x00", "ba", "c"}
+	res := s.Scan("b", 10)
+	if len(res) != len(expect) {
+		t.Fatalf("Expect %d, got %d\n", len(expect), len(res))
+	}
+	for i, node := range res {
+		if node.Key != expect[i] || node.Val.VersionHeader.Val != expect[i] {
+			t.Errorf("Expect %s, got %s\n", expect[i], node.Key)
+		}
+	}
+
+	var keys []string
+	s.Walk(func(key string, _ *values.Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if !sort.StringsAreSorted(keys) || len(keys) != 6 {
+		t.Errorf("Expect 6 sorted keys, got %q\n", keys)
+	}
+}
+
+func TestSkipList_Range(t *testing.T) {
+	lockMgr := manager.NewLockManager()
+	valMgr := manager2.NewValueManager()
+	s := NewSkipList(valMgr, lockMgr)
+	for i := 0; i < 5000; i++ {
+		s.MustGet(fmt.Sprintf("%05d", i), "")
+	}
+	for i := 0; i < 5000; i += 3 {
+		s.Vacuum(fmt.Sprintf("%05d", i))
+	}
+
+	collect := func(start string, end string, reverse bool) (keys []string) {
+		s.Range(start, end, reverse, func(key string, _ *values.Value) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return
+	}
+
+	forward := collect("00100", "04000", false)
+	backward := collect("00100", "04000", true)
+	if len(forward) != 2600 || len(backward) != len(forward) {
+		t.Fatalf("Expect 2600, got %d and %d\n", len(forward), len(backward))
+	}
+	if forward[0] != "00100" || forward[len(forward)-1] != "03998" {
+		t.Errorf("Expect [00100, 03998], got [%s, %s]\n", forward[0], forward[len(forward)-1])
+	}
+	for i := range forward {
+		if forward[i] != backward[len(backward)-1-i] {
+			t.Fatalf("Expect %s, got %s\n", forward[i], backward[len(backward)-1-i])
+		}
+	}
+
+	if all := collect("", "", true); len(all) != 3333 || all[0] != "04999" {
+		t.Errorf("Expect 3333 keys from 04999, got %d\n", len(all))
+	}
+	if none := collect("04000", "00100", false); len(none) != 0 {
+		t.Errorf("Expect empty, got %d\n", len(none))
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	cases := map[string]string{
+		"user:123:": "user:123;",
+		"a\xff":     "b",
+		"\xff\xff":  "",
+		"":          "",
+	}
+	for prefix, expect := range cases {
+		if end := PrefixEnd(prefix); end != expect {
+			t.Errorf("Expect %q, got %q\n", expect, end)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package manager
 
 import (
+	"simple-kv/pkg/config"
 	"simple-kv/pkg/locks"
+	"simple-kv/pkg/logger"
 	modules2 "simple-kv/pkg/modules"
 	"simple-kv/pkg/txns"
+	"simple-kv/pkg/workers"
 	"sync"
-	"time"
 )
 
 type Node struct {
@@ -29,11 +31,14 @@ func NewNode(isTxn bool, txn *txns.Txn, lock *locks.RWLock) *Node {
 	}
 }
 
+// DeadlockDetector sweeps the locks for the deadlocks missed as a txn waits,
+// see LockManager.Deadlock for the deadlocks found at once
 type DeadlockDetector struct {
 	TxnManager   modules2.TxnManager
 	ValueManager modules2.ValueManager
 	LockManager  *LockManager
 	latch        sync.Mutex
+	loop         *workers.Loop
 }
 
 func NewDeadlockDetector(txnManager modules2.TxnManager, valueManager modules2.ValueManager,
@@ -43,13 +48,22 @@ func NewDeadlockDetector(txnManager modules2.TxnManager, valueManager modules2.V
 		ValueManager: valueManager,
 		LockManager:  lockManager,
 		latch:        sync.Mutex{},
+		loop:         workers.NewLoop(),
 	}
 }
 
+// Run sweeps every config.DeadlockDetectInterval until Stop is called
 func (d *DeadlockDetector) Run() {
-	for _ = range time.Tick(time.Millisecond * 500) {
-		d.Detect()
-	}
+	d.loop.Run(config.DeadlockDetectInterval, d.Detect)
+}
+
+func (d *DeadlockDetector) Stop() {
+	d.loop.Stop()
+}
+
+// Reports returns the recent deadlocks from the oldest to the latest
+func (d *DeadlockDetector) Reports() []*DeadlockReport {
+	return d.LockManager.Reports.List()
 }
 
 func (d *DeadlockDetector) Detect() {
@@ -78,17 +92,14 @@ func (d *DeadlockDetector) Detect() {
 			lockNode.InDegree++
 		}
 
-		waitingTxn := lock.WaitingHead
-		for waitingTxn != nil {
-			txnID := waitingTxn.Txn.ID
+		for _, waitingTxn := range lock.GetWaitingTxns() {
+			txnID := waitingTxn.ID
 			if _, exists := txnNodes[txnID]; !exists {
-				txnNodes[txnID] = NewNode(true, waitingTxn.Txn, nil)
+				txnNodes[txnID] = NewNode(true, waitingTxn, nil)
 			}
 			lockNode.Nexts[txnNodes[txnID]] = struct{}{}
 			txnNodes[txnID].Prevs[lockNode] = struct{}{}
 			txnNodes[txnID].InDegree++
-
-			waitingTxn = waitingTxn.Next
 		}
 	}
 
@@ -121,47 +132,114 @@ func (d *DeadlockDetector) Detect() {
 			delete(nodes, node)
 		}
 
-		for node := range nodes {
-			if node.isTxn == false || node.Txn.Waiting == false {
+		// the nodes left are in or behind cycles, abort a waiting txn in a cycle
+		policy := d.LockManager.Policy
+		cycles := inCycles(nodes)
+		var node *Node
+		candidates := 0
+		for candidate := range cycles {
+			// the txn of a holder is nil once it has finished
+			if candidate.isTxn == false || candidate.Txn == nil || candidate.Txn.Waiting == false {
 				continue
 			}
+			candidates++
+			if node == nil || policy.Prefer(candidate.Txn, node.Txn) {
+				node = candidate
+			}
+		}
+		if node == nil {
+			return
+		}
+		report := NewDeadlockReport(node.Txn.ID, policy.Name(), cycleOf(node, cycles))
+		d.LockManager.Reports.Add(report)
+		logger.Inst.Infow("deadlock victim chosen by sweep",
+			"policy", report.Policy,
+			"victim", report.Victim,
+			"candidates", candidates,
+			"cycle", report.Summary())
+
+		// release the locks of the victim in the graph, from the edges instead of
+		// its read and write sets which its own goroutine keeps changing
+		txn := node.Txn
+		for lockNode := range node.Nexts {
+			delete(lockNode.Prevs, node)
+			lockNode.InDegree--
+			if lockNode.InDegree == 0 {
+				que = append(que, lockNode)
+			}
+		}
+		node.Nexts = map[*Node]struct{}{}
 
-			// rollback txn and release locks
-			txn := node.Txn
-			for valID := range txn.WriteSet {
-				val := d.ValueManager.GetValue(valID)
-				lockNode := lockNodes[val.HeaderLock]
-				txnNode := txnNodes[txn.ID]
-
-				delete(txnNode.Nexts, lockNode)
-				delete(lockNode.Prevs, txnNode)
-				lockNode.InDegree--
-				if lockNode.InDegree == 0 {
-					que = append(que, lockNode)
-				}
+		for waitingLockNode := range node.Prevs {
+			delete(waitingLockNode.Nexts, node)
+		}
+		que = append(que, node)
+
+		// the victim aborts itself once it is woken up
+		txn.Wake(report.Error())
+	}
+}
+
+// cycleOf returns the waits of a cycle in `cycles` through the txn node, which
+// goes from a txn to the locks it waits for, then to the txns holding them
+func cycleOf(node *Node, cycles map[*Node]struct{}) []*Wait {
+	visited := map[*Node]struct{}{}
+	var path []*Wait
+	var search func(txnNode *Node) bool
+	search = func(txnNode *Node) bool {
+		for lockNode := range txnNode.Prevs {
+			if _, ok := cycles[lockNode]; !ok {
+				continue
 			}
-			for valID := range txn.ReadSet {
-				val := d.ValueManager.GetValue(valID)
-				lockNode := lockNodes[val.HeaderLock]
-				txnNode := txnNodes[txn.ID]
-
-				delete(txnNode.Nexts, lockNode)
-				delete(lockNode.Prevs, txnNode)
-				lockNode.InDegree--
-				if lockNode.InDegree == 0 {
-					que = append(que, lockNode)
+			_, isRead := lockNode.Lock.Waits(txnNode.Txn)
+			path = append(path, &Wait{Txn: txnNode.Txn, Lock: lockNode.Lock, IsRead: isRead})
+			for holder := range lockNode.Prevs {
+				if holder == node {
+					return true
+				}
+				if _, ok := cycles[holder]; !ok || holder.Txn == nil {
+					continue
+				}
+				if _, ok := visited[holder]; ok {
+					continue
+				}
+				visited[holder] = struct{}{}
+				if search(holder) {
+					return true
 				}
 			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
 
-			for waitingLockNode := range node.Prevs {
-				lock := waitingLockNode.Lock
-				lock.CancelTask(node.Txn)
-				delete(waitingLockNode.Nexts, node)
-			}
-			que = append(que, node)
+	search(node)
+	return path
+}
+
+// inCycles returns the nodes in cycles, by trimming the nodes behind cycles
+// which depend on no node left
+func inCycles(nodes map[*Node]struct{}) map[*Node]struct{} {
+	left := map[*Node]struct{}{}
+	for node := range nodes {
+		left[node] = struct{}{}
+	}
 
-			node.Txn.Abort()
-			break
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for node := range left {
+			depended := false
+			for next := range node.Nexts {
+				if _, ok := left[next]; ok {
+					depended = true
+					break
+				}
+			}
+			if !depended {
+				delete(left, node)
+				trimmed = true
+			}
 		}
 	}
+	return left
 }
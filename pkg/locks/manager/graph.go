@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"simple-kv/pkg/locks"
+	"simple-kv/pkg/txns"
+	"sync"
+)
+
+// Wait is a txn waiting for a lock, which is held or queued for by the blockers
+type Wait struct {
+	Txn      *txns.Txn
+	Lock     *locks.RWLock
+	IsRead   bool
+	Blockers []*txns.Txn
+}
+
+// WaitGraph is the wait-for graph of the txns waiting for locks. It is updated
+// as the txns wait and the locks change hands, so a deadlock is found once the
+// txn closing the cycle waits, instead of rebuilding the graph from all locks.
+type WaitGraph struct {
+	// waits are the edges from the waiting txns to the txns they wait for
+	waits map[uint64]*Wait
+	latch sync.Mutex
+}
+
+func NewWaitGraph() *WaitGraph {
+	return &WaitGraph{
+		waits: map[uint64]*Wait{},
+		latch: sync.Mutex{},
+	}
+}
+
+// Set replaces the wait of the txn, the txn is removed if there is no blocker
+func (g *WaitGraph) Set(wait *Wait) {
+	g.latch.Lock()
+	defer g.latch.Unlock()
+
+	if len(wait.Blockers) == 0 {
+		delete(g.waits, wait.Txn.ID)
+		return
+	}
+	g.waits[wait.Txn.ID] = wait
+}
+
+// Cycle returns the waits of a cycle through the txn, from the txn to the one
+// waiting for it, or nil if the txn is not in a cycle
+func (g *WaitGraph) Cycle(txn *txns.Txn) []*Wait {
+	g.latch.Lock()
+	defer g.latch.Unlock()
+
+	visited := map[uint64]struct{}{}
+	var path []*Wait
+	var search func(wait *Wait) bool
+	search = func(wait *Wait) bool {
+		path = append(path, wait)
+		for _, next := range wait.Blockers {
+			if next.ID == txn.ID {
+				return true
+			}
+			if _, ok := visited[next.ID]; ok {
+				continue
+			}
+			visited[next.ID] = struct{}{}
+			if nextWait, ok := g.waits[next.ID]; ok && search(nextWait) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+
+	wait, ok := g.waits[txn.ID]
+	if !ok || !search(wait) {
+		return nil
+	}
+	return path
+}
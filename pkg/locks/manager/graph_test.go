@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"reflect"
+	"simple-kv/pkg/locks"
+	"simple-kv/pkg/txns"
+	"testing"
+)
+
+func newTxn(id uint64) *txns.Txn {
+	return &txns.Txn{
+		ID:       id,
+		ReadSet:  map[uint64]struct{}{},
+		WriteSet: map[uint64]*txns.WriteInfo{},
+	}
+}
+
+func cycleIDs(cycle []*Wait) []uint64 {
+	var res []uint64
+	for _, wait := range cycle {
+		res = append(res, wait.Txn.ID)
+	}
+	return res
+}
+
+func TestWaitGraph_Cycle(t *testing.T) {
+	manager := NewLockManager()
+	lock := manager.NewRWLock("A")
+	txn := map[uint64]*txns.Txn{}
+	for id := uint64(1); id <= 5; id++ {
+		txn[id] = newTxn(id)
+	}
+
+	// 1 -> {4, 2}, 2 -> 3, 3 -> 1, 5 -> 1, and 4 waits for nothing
+	graph := NewWaitGraph()
+	graph.Set(&Wait{Txn: txn[1], Lock: lock, Blockers: []*txns.Txn{txn[4], txn[2]}})
+	graph.Set(&Wait{Txn: txn[2], Lock: lock, Blockers: []*txns.Txn{txn[3]}})
+	graph.Set(&Wait{Txn: txn[5], Lock: lock, Blockers: []*txns.Txn{txn[1]}})
+	if cycle := graph.Cycle(txn[1]); cycle != nil {
+		t.Errorf("Expect no cycle, got %v\n", cycleIDs(cycle))
+	}
+
+	graph.Set(&Wait{Txn: txn[3], Lock: lock, Blockers: []*txns.Txn{txn[1]}})
+	for start, expect := range map[uint64][]uint64{1: {1, 2, 3}, 2: {2, 3, 1}, 3: {3, 1, 2}} {
+		if cycle := cycleIDs(graph.Cycle(txn[start])); !reflect.DeepEqual(cycle, expect) {
+			t.Errorf("Expect %v, got %v\n", expect, cycle)
+		}
+	}
+	// the txns behind the cycle or waiting for nothing are not in it
+	for _, id := range []uint64{4, 5} {
+		if cycle := graph.Cycle(txn[id]); cycle != nil {
+			t.Errorf("Expect no cycle through %d, got %v\n", id, cycleIDs(cycle))
+		}
+	}
+
+	// the cycle is broken once a txn stops waiting
+	graph.Set(&Wait{Txn: txn[2], Lock: lock})
+	if cycle := graph.Cycle(txn[1]); cycle != nil {
+		t.Errorf("Expect no cycle, got %v\n", cycleIDs(cycle))
+	}
+}
+
+func TestLockManager_Deadlock(t *testing.T) {
+	manager := NewLockManager()
+	manager.Policy = LowestPriority
+	lock := manager.NewRWLock("A")
+	txn1, txn2, txn3 := newTxn(1), newTxn(2), newTxn(3)
+	txn1.Priority, txn2.Priority, txn3.Priority = 3, 1, 2
+
+	manager.WaitFor(lock, &locks.Task{Txn: txn1}, []*txns.Txn{txn2})
+	manager.WaitFor(lock, &locks.Task{Txn: txn2}, []*txns.Txn{txn3})
+	if victim, err := manager.Deadlock(txn1); victim != nil || err != nil {
+		t.Errorf("Expect no deadlock, got %v (err=%v)\n", victim, err)
+	}
+
+	manager.WaitFor(lock, &locks.Task{Txn: txn3}, []*txns.Txn{txn1})
+	victim, err := manager.Deadlock(txn3)
+	if victim != txn2 || err == nil {
+		t.Errorf("Expect txn 2 aborted, got %v (err=%v)\n", victim, err)
+	}
+	if reports := victims(manager.Reports.List()); !reflect.DeepEqual(reports, []uint64{2}) {
+		t.Errorf("Expect [2], got %v\n", reports)
+	}
+}
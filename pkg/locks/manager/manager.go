@@ -1,18 +1,28 @@
 package manager
 
 import (
+	"simple-kv/pkg/config"
 	"simple-kv/pkg/locks"
+	"simple-kv/pkg/logger"
+	"simple-kv/pkg/txns"
 	"sync"
 )
 
 type LockManager struct {
 	ActiveLocks map[*locks.RWLock]struct{}
-	latch       sync.Mutex
+	Graph       *WaitGraph
+	// Policy chooses the victims of deadlocks
+	Policy  VictimPolicy
+	Reports *DeadlockReports
+	latch   sync.Mutex
 }
 
 func NewLockManager() *LockManager {
 	return &LockManager{
 		ActiveLocks: map[*locks.RWLock]struct{}{},
+		Graph:       NewWaitGraph(),
+		Policy:      configuredVictimPolicy(),
+		Reports:     NewDeadlockReports(config.DeadlockReportSize),
 		latch:       sync.Mutex{},
 	}
 }
@@ -40,14 +50,48 @@ func (manager *LockManager) InactiveLock(lock *locks.RWLock) {
 	delete(manager.ActiveLocks, lock)
 }
 
-func (manager *LockManager) NewRWLock() *locks.RWLock {
+func (manager *LockManager) WaitFor(lock *locks.RWLock, task *locks.Task, blockers []*txns.Txn) {
+	manager.Graph.Set(&Wait{
+		Txn:      task.Txn,
+		Lock:     lock,
+		IsRead:   task.IsRead,
+		Blockers: blockers,
+	})
+}
+
+// Deadlock checks whether the txn waits in a cycle, then it returns the victim
+// chosen by the policy and the error to wake up the victim with
+func (manager *LockManager) Deadlock(txn *txns.Txn) (*txns.Txn, error) {
+	cycle := manager.Graph.Cycle(txn)
+	if cycle == nil {
+		return nil, nil
+	}
+
+	victim := cycle[0].Txn
+	for _, wait := range cycle {
+		if manager.Policy.Prefer(wait.Txn, victim) {
+			victim = wait.Txn
+		}
+	}
+	report := NewDeadlockReport(victim.ID, manager.Policy.Name(), cycle)
+	manager.Reports.Add(report)
+	logger.Inst.Infow("deadlock victim chosen",
+		"policy", report.Policy,
+		"victim", report.Victim,
+		"cycle", report.Summary())
+	return victim, report.Error()
+}
+
+func (manager *LockManager) NewRWLock(key string) *locks.RWLock {
 	mutex := sync.Mutex{}
 	return &locks.RWLock{
+		Key:           key,
 		WaitingHead:   nil,
 		WaitingTail:   nil,
 		Condition:     sync.NewCond(&mutex),
 		WritingTxnID:  0,
 		ReadingTxnIDs: map[uint64]struct{}{},
+		Holders:       map[uint64]*txns.Txn{},
 		Op:            manager,
 		Latch:         &mutex,
 	}
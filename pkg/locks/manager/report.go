@@ -0,0 +1,115 @@
+package manager
+
+import (
+	"fmt"
+	"simple-kv/pkg/errs"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeadlockStep is a txn in a deadlock and the lock it waits for
+type DeadlockStep struct {
+	TxnID  uint64
+	Client string
+	Key    string
+	IsRead bool
+}
+
+func (s *DeadlockStep) String() string {
+	client := ""
+	if s.Client != "" {
+		client = fmt.Sprintf(" (%s)", s.Client)
+	}
+	mode := "write"
+	if s.IsRead {
+		mode = "read"
+	}
+	return fmt.Sprintf("txn %d%s waits for %s lock on %q", s.TxnID, client, mode, s.Key)
+}
+
+type DeadlockReport struct {
+	Time   time.Time
+	Victim uint64
+	Policy string
+	// Cycle is in the order of waiting, the last txn waits for the first one
+	Cycle []*DeadlockStep
+}
+
+func NewDeadlockReport(victim uint64, policy string, cycle []*Wait) *DeadlockReport {
+	report := &DeadlockReport{
+		Time:   time.Now(),
+		Victim: victim,
+		Policy: policy,
+	}
+	for _, wait := range cycle {
+		report.Cycle = append(report.Cycle, &DeadlockStep{
+			TxnID:  wait.Txn.ID,
+			Client: wait.Txn.Client,
+			Key:    wait.Lock.Key,
+			IsRead: wait.IsRead,
+		})
+	}
+	return report
+}
+
+// Summary is the cycle as `txn 1 waits for ... -> txn 2 waits for ... -> txn 1`
+func (r *DeadlockReport) Summary() string {
+	if len(r.Cycle) == 0 {
+		return "unknown cycle"
+	}
+	steps := make([]string, 0, len(r.Cycle)+1)
+	for _, step := range r.Cycle {
+		steps = append(steps, step.String())
+	}
+	steps = append(steps, fmt.Sprintf("txn %d", r.Cycle[0].TxnID))
+	return strings.Join(steps, " -> ")
+}
+
+func (r *DeadlockReport) String() string {
+	return fmt.Sprintf("%s victim=%d policy=%s: %s", r.Time.Format(time.RFC3339), r.Victim, r.Policy, r.Summary())
+}
+
+// Error is to wake up the victim with
+func (r *DeadlockReport) Error() error {
+	return errs.New(errs.DeadlockVictim, "txn aborted since deadlock occured: txn=%d, cycle: %s", r.Victim, r.Summary())
+}
+
+// DeadlockReports is a ring buffer of the recent deadlock reports
+type DeadlockReports struct {
+	reports []*DeadlockReport
+	// next is where to put the next report, the oldest one once the ring is full
+	next  int
+	latch sync.Mutex
+}
+
+func NewDeadlockReports(size int) *DeadlockReports {
+	return &DeadlockReports{
+		reports: make([]*DeadlockReport, 0, size),
+		next:    0,
+		latch:   sync.Mutex{},
+	}
+}
+
+func (r *DeadlockReports) Add(report *DeadlockReport) {
+	r.latch.Lock()
+	defer r.latch.Unlock()
+
+	if cap(r.reports) == 0 {
+		return
+	}
+	if len(r.reports) < cap(r.reports) {
+		r.reports = append(r.reports, report)
+	} else {
+		r.reports[r.next] = report
+	}
+	r.next = (r.next + 1) % cap(r.reports)
+}
+
+// List returns the reports from the oldest to the latest
+func (r *DeadlockReports) List() []*DeadlockReport {
+	r.latch.Lock()
+	defer r.latch.Unlock()
+
+	return append(append([]*DeadlockReport(nil), r.reports[r.next:]...), r.reports[:r.next]...)
+}
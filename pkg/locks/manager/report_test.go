@@ -0,0 +1,65 @@
+package manager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func victims(reports []*DeadlockReport) []uint64 {
+	var res []uint64
+	for _, report := range reports {
+		res = append(res, report.Victim)
+	}
+	return res
+}
+
+func TestDeadlockReports_List(t *testing.T) {
+	reports := NewDeadlockReports(3)
+	if list := reports.List(); len(list) != 0 {
+		t.Errorf("Expect no report, got %v\n", victims(list))
+	}
+
+	reports.Add(&DeadlockReport{Victim: 1})
+	reports.Add(&DeadlockReport{Victim: 2})
+	if list := victims(reports.List()); !reflect.DeepEqual(list, []uint64{1, 2}) {
+		t.Errorf("Expect [1 2], got %v\n", list)
+	}
+
+	// the oldest reports are overwritten once the ring is full
+	for victim := uint64(3); victim <= 7; victim++ {
+		reports.Add(&DeadlockReport{Victim: victim})
+	}
+	if list := victims(reports.List()); !reflect.DeepEqual(list, []uint64{5, 6, 7}) {
+		t.Errorf("Expect [5 6 7], got %v\n", list)
+	}
+}
+
+func TestDeadlockReports_Disabled(t *testing.T) {
+	reports := NewDeadlockReports(0)
+	reports.Add(&DeadlockReport{Victim: 1})
+	if list := reports.List(); len(list) != 0 {
+		t.Errorf("Expect no report, got %v\n", victims(list))
+	}
+}
+
+func TestDeadlockReport_Summary(t *testing.T) {
+	manager := NewLockManager()
+	txn1, txn2 := newTxn(1), newTxn(2)
+	txn1.Client = "127.0.0.1:50001"
+	report := NewDeadlockReport(2, Youngest.Name(), []*Wait{
+		{Txn: txn1, Lock: manager.NewRWLock("B")},
+		{Txn: txn2, Lock: manager.NewRWLock("A"), IsRead: true},
+	})
+
+	expect := `txn 1 (127.0.0.1:50001) waits for write lock on "B" -> txn 2 waits for read lock on "A" -> txn 1`
+	if summary := report.Summary(); summary != expect {
+		t.Errorf("Expect %s, got %s\n", expect, summary)
+	}
+	if err := report.Error(); !strings.Contains(err.Error(), expect) {
+		t.Errorf("Expect the cycle in the error, got %v\n", err)
+	}
+	if summary := (&DeadlockReport{}).Summary(); summary != "unknown cycle" {
+		t.Errorf("Expect unknown cycle, got %s\n", summary)
+	}
+}
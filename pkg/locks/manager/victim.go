@@ -0,0 +1,88 @@
+package manager
+
+import (
+	"fmt"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/logger"
+	"simple-kv/pkg/txns"
+	"sort"
+)
+
+// VictimPolicy chooses which txn of a deadlock to abort
+type VictimPolicy interface {
+	Name() string
+	// Prefer tells whether `a` is a better victim than `b`
+	Prefer(a *txns.Txn, b *txns.Txn) bool
+}
+
+type victimPolicy struct {
+	name string
+	// cost is what the victim loses, the txn with the lower cost is aborted
+	cost func(txn *txns.Txn) int
+}
+
+func (p *victimPolicy) Name() string {
+	return p.name
+}
+
+// Prefer falls back to the younger txn if the costs are equal
+func (p *victimPolicy) Prefer(a *txns.Txn, b *txns.Txn) bool {
+	if costA, costB := p.cost(a), p.cost(b); costA != costB {
+		return costA < costB
+	}
+	return a.ID > b.ID
+}
+
+var (
+	// Youngest aborts the txn began last, which has done the least work in general
+	Youngest VictimPolicy = &victimPolicy{name: "youngest", cost: func(txn *txns.Txn) int { return 0 }}
+	// FewestLocks aborts the txn holding the fewest read and write locks
+	FewestLocks VictimPolicy = &victimPolicy{name: "fewest-locks", cost: func(txn *txns.Txn) int { return txn.LockCount() }}
+	// SmallestWriteSet aborts the txn with the fewest writes to undo
+	SmallestWriteSet VictimPolicy = &victimPolicy{name: "smallest-write-set", cost: func(txn *txns.Txn) int { return txn.WriteCount() }}
+	// LowestPriority aborts the txn with the lowest priority assigned by the client
+	LowestPriority VictimPolicy = &victimPolicy{name: "lowest-priority", cost: func(txn *txns.Txn) int { return txn.Priority }}
+)
+
+var victimPolicies = map[string]VictimPolicy{}
+
+func init() {
+	for _, policy := range []VictimPolicy{Youngest, FewestLocks, SmallestWriteSet, LowestPriority} {
+		RegisterVictimPolicy(policy)
+	}
+}
+
+// RegisterVictimPolicy makes the policy selectable by its name
+func RegisterVictimPolicy(policy VictimPolicy) {
+	victimPolicies[policy.Name()] = policy
+}
+
+func GetVictimPolicy(name string) (VictimPolicy, error) {
+	policy, ok := victimPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("no such victim policy: policy=%s, policies=%v", name, VictimPolicyNames())
+	}
+	return policy, nil
+}
+
+// configuredVictimPolicy returns the policy of config.DeadlockVictimPolicy, or
+// Youngest if there is no such policy
+func configuredVictimPolicy() VictimPolicy {
+	policy, err := GetVictimPolicy(config.DeadlockVictimPolicy)
+	if err != nil {
+		logger.Inst.Warnw("fall back to the default victim policy",
+			"policy", Youngest.Name(),
+			"err", err)
+		return Youngest
+	}
+	return policy
+}
+
+func VictimPolicyNames() []string {
+	names := make([]string, 0, len(victimPolicies))
+	for name := range victimPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"simple-kv/pkg/txns"
+	"testing"
+)
+
+func TestVictimPolicy_Prefer(t *testing.T) {
+	// txn 1 is the oldest with the most locks, the most writes and the highest priority
+	txn1, txn2, txn3 := newTxn(1), newTxn(2), newTxn(3)
+	txn1.Priority, txn2.Priority, txn3.Priority = 3, 1, 2
+	txn1.SetReading(1)
+	txn1.SetWriting(2, "b", 0)
+	txn1.SetWriting(3, "c", 0)
+	txn2.SetReading(4)
+	txn2.SetReading(5)
+	txn2.SetWriting(6, "f", 0)
+	txn3.SetWriting(7, "g", 0)
+
+	tests := []struct {
+		policy VictimPolicy
+		victim uint64
+	}{
+		{Youngest, 3},
+		{FewestLocks, 3},
+		// txn 2 and txn 3 write as much, then the younger one is aborted
+		{SmallestWriteSet, 3},
+		{LowestPriority, 2},
+	}
+	for _, test := range tests {
+		victim := txn1
+		for _, txn := range []*txns.Txn{txn2, txn3} {
+			if test.policy.Prefer(txn, victim) {
+				victim = txn
+			}
+		}
+		if victim.ID != test.victim {
+			t.Errorf("%s: Expect %d, got %d\n", test.policy.Name(), test.victim, victim.ID)
+		}
+	}
+}
+
+func TestVictimPolicy_Counts(t *testing.T) {
+	txn := newTxn(1)
+	txn.SetReading(1)
+	txn.SetReading(1)
+	txn.SetWriting(2, "b", 0)
+	txn.SetWriting(2, "b", 0)
+	if txn.LockCount() != 2 || txn.WriteCount() != 1 {
+		t.Errorf("Expect 2 locks and 1 write, got %d and %d\n", txn.LockCount(), txn.WriteCount())
+	}
+}
+
+func TestGetVictimPolicy(t *testing.T) {
+	for _, name := range VictimPolicyNames() {
+		if policy, err := GetVictimPolicy(name); err != nil || policy.Name() != name {
+			t.Errorf("Expect %s, got %v (err=%v)\n", name, policy, err)
+		}
+	}
+	if _, err := GetVictimPolicy("oldest"); err == nil {
+		t.Errorf("Expect error, got nil\n")
+	}
+}
@@ -1,10 +1,12 @@
 package locks
 
 import (
-	"fmt"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
 	"simple-kv/pkg/txns"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var taskCounter = uint64(0)
@@ -13,6 +15,8 @@ type Task struct {
 	ID     uint64
 	Txn    *txns.Txn
 	IsRead bool
+	// Err fails the task once it is removed from the queue without being granted
+	Err error
 
 	Next *Task
 }
@@ -26,19 +30,40 @@ func NewTask(txn *txns.Txn, isRead bool) *Task {
 	}
 }
 
+// The ways to handle deadlocks, see config.DeadlockMode.
+// Detect lets the txns wait and the deadlock detector breaks the cycles. The
+// others prevent cycles by the age of txns, where the txn with the smaller ID
+// is older: in WaitDie a txn only waits for younger ones and dies instead of
+// waiting for older ones, in WoundWait a txn wounds the younger ones it waits
+// for, which abort themselves once they wait or are woken up.
+const (
+	Detect    = "detect"
+	WaitDie   = "wait-die"
+	WoundWait = "wound-wait"
+)
+
 type Operator interface {
 	ActiveLock(lock *RWLock)
 	InactiveLock(lock *RWLock)
+	// WaitFor updates the txns the task waits for in the wait-for graph, none
+	// means the task stops waiting
+	WaitFor(lock *RWLock, task *Task, blockers []*txns.Txn)
+	// Deadlock returns the victim to wake up with the error if the txn waits in a cycle
+	Deadlock(txn *txns.Txn) (*txns.Txn, error)
 }
 
 type RWLock struct {
+	// Key is of the record guarded by the lock, to report deadlocks
+	Key         string
 	WaitingHead *Task
 	WaitingTail *Task
 
 	Condition     *sync.Cond
 	WritingTxnID  uint64
 	ReadingTxnIDs map[uint64]struct{}
-	AllowTaskID   uint64
+	// Holders are the txns holding the lock, to compare ages with them
+	Holders     map[uint64]*txns.Txn
+	AllowTaskID uint64
 
 	Op    Operator
 	Latch *sync.Mutex
@@ -59,6 +84,17 @@ func (l *RWLock) GetReadingTxnIDs() (m map[uint64]struct{}) {
 	return
 }
 
+// GetWaitingTxns returns the txns in the waiting queue, from the head to the tail
+func (l *RWLock) GetWaitingTxns() (res []*txns.Txn) {
+	l.Latch.Lock()
+	defer l.Latch.Unlock()
+
+	for task := l.WaitingHead; task != nil; task = task.Next {
+		res = append(res, task.Txn)
+	}
+	return
+}
+
 func (l *RWLock) PushTask(txn *txns.Txn, isRead bool) *Task {
 	if l.WaitingTail == nil {
 		l.WaitingHead = NewTask(txn, isRead)
@@ -82,45 +118,187 @@ func (l *RWLock) PopTask() *Task {
 	return task
 }
 
-func (l *RWLock) CancelTask(txn *txns.Txn) {
-	if l.WaitingHead == nil {
-		return
+// Waits tells whether the txn waits for the lock, and whether it waits to read
+func (l *RWLock) Waits(txn *txns.Txn) (waiting bool, isRead bool) {
+	l.Latch.Lock()
+	defer l.Latch.Unlock()
+
+	for task := l.WaitingHead; task != nil; task = task.Next {
+		if task.Txn == txn {
+			return true, task.IsRead
+		}
 	}
-	if l.WaitingHead.Txn == txn {
-		l.WaitingHead.Txn = nil
-		l.WaitingHead = l.WaitingHead.Next
-		if l.WaitingHead == nil {
-			l.WaitingTail = nil
+	return false, false
+}
+
+// removeTask removes the task from the waiting queue, it tells whether the
+// task is found
+func (l *RWLock) removeTask(task *Task) bool {
+	var prev *Task
+	for iter := l.WaitingHead; iter != nil; prev, iter = iter, iter.Next {
+		if iter != task {
+			continue
 		}
-		return
+
+		if prev == nil {
+			l.WaitingHead = iter.Next
+		} else {
+			prev.Next = iter.Next
+		}
+		if iter == l.WaitingTail {
+			l.WaitingTail = prev
+		}
+		return true
+	}
+	return false
+}
+
+// fail removes the task from the queue with `err` unless it has been granted,
+// and wakes up its txn
+func (l *RWLock) fail(task *Task, err error) {
+	l.Latch.Lock()
+	if l.removeTask(task) {
+		task.Err = err
+		l.updateWaits()
 	}
+	l.Latch.Unlock()
+	l.Condition.Broadcast()
+}
 
-	head := l.WaitingHead
-	for head.Next != nil {
-		if head.Next.Txn != txn {
+// conflicts returns the txns to get the lock before the txn: the holders
+// conflicting with it and the txns queued
+func (l *RWLock) conflicts(txn *txns.Txn, isRead bool) []*txns.Txn {
+	var res []*txns.Txn
+	for txnID, holder := range l.Holders {
+		if txnID == txn.ID || (isRead && txnID != l.WritingTxnID) {
 			continue
 		}
+		res = append(res, holder)
+	}
+	for task := l.WaitingHead; task != nil; task = task.Next {
+		if task.Txn != txn {
+			res = append(res, task.Txn)
+		}
+	}
+	return res
+}
 
-		if head.Next == l.WaitingTail {
-			l.WaitingTail = head
+// blockers returns the txns the task waits for: the holders and the tasks
+// queued ahead conflicting with it
+func (l *RWLock) blockers(task *Task) []*txns.Txn {
+	var res []*txns.Txn
+	for txnID, holder := range l.Holders {
+		if txnID == task.Txn.ID || (task.IsRead && txnID != l.WritingTxnID) {
+			continue
 		}
-		head.Next.Txn = nil
-		head.Next = head.Next.Next
-		break
+		res = append(res, holder)
 	}
+	for ahead := l.WaitingHead; ahead != nil && ahead != task; ahead = ahead.Next {
+		if ahead.Txn == task.Txn || (task.IsRead && ahead.IsRead) {
+			continue
+		}
+		res = append(res, ahead.Txn)
+	}
+	return res
 }
 
+// updateWaits updates the wait-for graph for the queued tasks, since the txns
+// they wait for change as the lock is granted and released
+func (l *RWLock) updateWaits() {
+	if config.DeadlockMode != Detect {
+		return
+	}
+	for task := l.WaitingHead; task != nil; task = task.Next {
+		l.Op.WaitFor(l, task, l.blockers(task))
+	}
+}
+
+// wait queues the txn until the lock is granted, it fails once the task is
+// canceled, the lock timeout of the txn is exceeded, or the txn is not allowed
+// to wait by the deadlock mode
 func (l *RWLock) wait(txn *txns.Txn, isRead bool) error {
+	if txn.LockTimeout == txns.NoWait {
+		return errs.New(errs.LockTimeout, "lock not available with NOWAIT: txn=%d", txn.ID)
+	}
+
+	var wounded []*txns.Txn
+	switch config.DeadlockMode {
+	case WaitDie:
+		for _, other := range l.conflicts(txn, isRead) {
+			if other.ID < txn.ID {
+				return errs.New(errs.DeadlockVictim, "txn died instead of waiting for an older one: txn=%d, older=%d", txn.ID, other.ID)
+			}
+		}
+	case WoundWait:
+		for _, other := range l.conflicts(txn, isRead) {
+			if other.ID > txn.ID {
+				wounded = append(wounded, other)
+			}
+		}
+	}
+
 	txn.Waiting = true
+	defer func() {
+		txn.Waiting = false
+	}()
 	task := l.PushTask(txn, isRead)
-	if task.Txn != nil && task.ID > l.AllowTaskID {
+
+	txn.SetWaker(func(err error) {
+		l.fail(task, err)
+	})
+	defer txn.SetWaker(nil)
+	if txn.Wounded() {
+		l.removeTask(task)
+		return errs.New(errs.DeadlockVictim, "txn wounded by an older one: txn=%d", txn.ID)
+	}
+
+	if txn.LockTimeout > 0 {
+		timeout := txn.LockTimeout
+		timer := time.AfterFunc(timeout, func() {
+			l.fail(task, errs.New(errs.LockTimeout, "lock wait timeout exceeded: txn=%d, timeout=%v", txn.ID, timeout))
+		})
+		defer timer.Stop()
+	}
+
+	var (
+		victim    *txns.Txn
+		victimErr error
+	)
+	if config.DeadlockMode == Detect {
+		// the txn closing a cycle finds the deadlock at once
+		defer l.Op.WaitFor(l, task, nil)
+		l.updateWaits()
+		if victim, victimErr = l.Op.Deadlock(txn); victim == txn {
+			l.removeTask(task)
+			l.updateWaits()
+			return victimErr
+		}
+	}
+
+	// the txns to wake up may wait for this lock as well
+	if len(wounded) > 0 || victim != nil {
+		l.Latch.Unlock()
+		for _, other := range wounded {
+			other.Wound()
+		}
+		if victim != nil {
+			victim.Wake(victimErr)
+		}
+		l.Latch.Lock()
+	}
+
+	for task.Err == nil && task.ID > l.AllowTaskID {
 		l.Condition.Wait()
 	}
-	txn.Waiting = false
-	if task.Txn == nil {
-		return fmt.Errorf("txn aborted since deadlock occured")
+	return task.Err
+}
+
+// abortVictim aborts the txn failed as the victim of a deadlock, or which dies
+// or is wounded to prevent deadlocks
+func abortVictim(txn *txns.Txn, err error) {
+	if errs.Is(err, errs.DeadlockVictim) {
+		_ = txn.Abort()
 	}
-	return nil
 }
 
 func (l *RWLock) nextTask() bool {
@@ -148,7 +326,11 @@ func (l *RWLock) nextTask() bool {
 	return true
 }
 
-func (l *RWLock) RLock(txn *txns.Txn) error {
+func (l *RWLock) RLock(txn *txns.Txn) (err error) {
+	// abort after the latch is released, since the txn may hold the lock to release
+	defer func() {
+		abortVictim(txn, err)
+	}()
 	l.Latch.Lock()
 	defer l.Latch.Unlock()
 
@@ -162,15 +344,26 @@ func (l *RWLock) RLock(txn *txns.Txn) error {
 
 	// if there is no waiting task, then do it immediately
 	l.ReadingTxnIDs[txn.ID] = struct{}{}
+	l.Holders[txn.ID] = txn
 	l.Op.ActiveLock(l)
+	l.updateWaits()
 	return nil
 }
 
 func (l *RWLock) RUnlock(txn *txns.Txn) {
 	l.Latch.Lock()
 
+	// the read lock has been upgraded to the write lock
+	if _, reading := l.ReadingTxnIDs[txn.ID]; !reading {
+		l.Latch.Unlock()
+		return
+	}
+
 	// ASSERT: reading count > 0
 	delete(l.ReadingTxnIDs, txn.ID)
+	if txn.ID != l.WritingTxnID {
+		delete(l.Holders, txn.ID)
+	}
 	boardcast := false
 	count := len(l.ReadingTxnIDs)
 	if count == 1 {
@@ -179,6 +372,7 @@ func (l *RWLock) RUnlock(txn *txns.Txn) {
 		l.Op.InactiveLock(l)
 		boardcast = l.nextTask()
 	}
+	l.updateWaits()
 	l.Latch.Unlock()
 
 	if boardcast {
@@ -186,11 +380,17 @@ func (l *RWLock) RUnlock(txn *txns.Txn) {
 	}
 }
 
-func (l *RWLock) Lock(txn *txns.Txn) error {
+func (l *RWLock) Lock(txn *txns.Txn) (err error) {
+	defer func() {
+		abortVictim(txn, err)
+	}()
 	l.Latch.Lock()
 	defer l.Latch.Unlock()
 
-	if atomic.LoadUint64(&l.WritingTxnID) != 0 || len(l.ReadingTxnIDs) != 0 {
+	// the only reader upgrades its read lock at once
+	if _, reading := l.ReadingTxnIDs[txn.ID]; reading && len(l.ReadingTxnIDs) == 1 && l.WritingTxnID == 0 {
+		delete(l.ReadingTxnIDs, txn.ID)
+	} else if atomic.LoadUint64(&l.WritingTxnID) != 0 || len(l.ReadingTxnIDs) != 0 {
 		err := l.wait(txn, false)
 		if err != nil {
 			return err
@@ -199,15 +399,21 @@ func (l *RWLock) Lock(txn *txns.Txn) error {
 
 	// if there is no waiting task, then do it immediately
 	atomic.StoreUint64(&l.WritingTxnID, txn.ID)
+	l.Holders[txn.ID] = txn
 	l.Op.ActiveLock(l)
+	l.updateWaits()
 	return nil
 }
 
 func (l *RWLock) Unlock(_ *txns.Txn) {
 	l.Latch.Lock()
+	if _, reading := l.ReadingTxnIDs[l.WritingTxnID]; !reading {
+		delete(l.Holders, l.WritingTxnID)
+	}
 	atomic.StoreUint64(&l.WritingTxnID, 0)
 	l.Op.InactiveLock(l)
 	l.nextTask()
+	l.updateWaits()
 	l.Latch.Unlock()
 
 	l.Condition.Broadcast()
@@ -223,29 +429,17 @@ func (l *RWLock) tryUpgrade() {
 		txnID = t
 	}
 
-	if l.WaitingHead.Txn.ID == txnID {
-		l.WaitingHead.ID = l.AllowTaskID
-		l.WaitingHead.Txn.Waiting = false
-		l.WaitingHead = l.WaitingHead.Next
-		delete(l.ReadingTxnIDs, txnID)
-		l.Op.InactiveLock(l)
-		l.Condition.Broadcast()
-		return
-	}
-
-	prev := l.WaitingHead
-	task := prev.Next
+	task := l.WaitingHead
 	for task != nil && task.Txn.ID != txnID {
 		task = task.Next
 	}
-
 	if task == nil {
 		return
 	}
 
+	l.removeTask(task)
 	task.ID = l.AllowTaskID
 	task.Txn.Waiting = false
-	prev.Next = task.Next
 	delete(l.ReadingTxnIDs, txnID)
 	l.Op.InactiveLock(l)
 	l.Condition.Broadcast()
@@ -5,5 +5,5 @@ import (
 )
 
 type LockManager interface {
-	NewRWLock() *locks.RWLock
+	NewRWLock(key string) *locks.RWLock
 }
@@ -5,6 +5,8 @@ import (
 )
 
 type TxnManager interface {
+	NewTxn() *txns.Txn
+	Freeze(fn func())
 	GetTxn(txnID uint64) *txns.Txn
 	GetActiveTxns() []*txns.Txn
 	Commit(txn *txns.Txn) error
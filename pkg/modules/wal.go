@@ -0,0 +1,9 @@
+package modules
+
+import (
+	"simple-kv/pkg/wal"
+)
+
+type WAL interface {
+	Append(record *wal.Record) error
+}
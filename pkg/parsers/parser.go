@@ -17,10 +17,21 @@ func NewParser() *Parser {
 }
 
 /*
+<request>  := <command> [<lock>] [PRIORITY <number>]
 <command>  := <type> <strings>
+			| SCAN <string> <number>
+			| SCAN <string> <string> <options>
+			| SCAN CURSOR <cursor>
+			| PSCAN <string> [<number>]
+			| BEGIN | COMMIT | ABORT
+			| SHOW <subject>
 <strings>  := <string> <strings>
 			| <string>
+<options>  := [LIMIT <number>] [REVERSE]
+<lock>     := NOWAIT | TIMEOUT <number>
 <string>   := " .*? "
+<cursor>   := [0-9a-z]+
+<subject>  := DEADLOCKS
 */
 
 func (p *Parser) Parse(input string) (*protos.Command, error) {
@@ -68,6 +79,12 @@ func (p *Parser) Parse(input string) (*protos.Command, error) {
 			return nil, err
 		}
 
+		if word, j, _ := p.getChars(next, unicode.IsLetter); strings.EqualFold(word, "CURSOR") {
+			t = protos.Fetch
+			content, next, err = p.getCursor(j)
+			break
+		}
+
 		var key, count string
 		if key, next, err = p.getString(next); err != nil {
 			break
@@ -75,13 +92,49 @@ func (p *Parser) Parse(input string) (*protos.Command, error) {
 		if next, err = p.dropSpaces(next); err != nil {
 			break
 		}
+		if p.Input[next] == '"' {
+			t = protos.Range
+			content, next, err = p.getRange(key, next)
+			break
+		}
 		if count, next, err = p.getChars(next, unicode.IsDigit); err != nil {
 			break
 		}
 		content = append(content, key, count)
 
+	case protos.PScan:
+		next, err = p.dropSpaces(next)
+		if err != nil {
+			return nil, err
+		}
+
+		var prefix string
+		if prefix, next, err = p.getString(next); err != nil {
+			break
+		}
+
+		count := "0"
+		if j, err := p.dropSpaces(next); err == nil && j < p.Length && unicode.IsDigit(rune(p.Input[j])) {
+			count, next, _ = p.getChars(j, unicode.IsDigit)
+		}
+		content = append(content, prefix, count)
+
 	case protos.Begin, protos.Commit, protos.Abort:
 		err = nil
+
+	case protos.Show:
+		next, err = p.dropSpaces(next)
+		if err != nil {
+			return nil, err
+		}
+
+		var subject string
+		subject, next, _ = p.getChars(next, unicode.IsLetter)
+		if !strings.EqualFold(subject, "DEADLOCKS") {
+			err = fmt.Errorf("DEADLOCKS needed here:\n%s", p.errorOn(next-len(subject)))
+			break
+		}
+		content = append(content, strings.ToUpper(subject))
 	default:
 		err = fmt.Errorf("invalid type:\n%s", p.errorOn(next-1))
 	}
@@ -90,6 +143,12 @@ func (p *Parser) Parse(input string) (*protos.Command, error) {
 		return nil, err
 	}
 
+	var options []string
+	if options, next, err = p.getRequestOptions(next); err != nil {
+		return nil, err
+	}
+	content = append(content, options...)
+
 	if next != p.Length {
 		next, _ = p.dropSpaces(next)
 	}
@@ -101,6 +160,93 @@ func (p *Parser) Parse(input string) (*protos.Command, error) {
 	return protos.NewCommand(t, content), nil
 }
 
+// getRange parses `<string> <options>` after the start key of a range scan
+func (p *Parser) getRange(start string, i int) ([]string, int, error) {
+	end, next, err := p.getString(i)
+	if err != nil {
+		return nil, next, err
+	}
+
+	limit, reverse := "0", "0"
+	for next < p.Length {
+		j, err := p.dropSpaces(next)
+		if err != nil {
+			break
+		}
+		option, j, _ := p.getChars(j, unicode.IsLetter)
+
+		switch strings.ToUpper(option) {
+		case "LIMIT":
+			if j, err = p.dropSpaces(j); err != nil {
+				return nil, j, err
+			}
+			if limit, j, _ = p.getChars(j, unicode.IsDigit); limit == "" {
+				return nil, j, fmt.Errorf("a number needed here:\n%s", p.errorOn(j))
+			}
+		case "REVERSE":
+			reverse = "1"
+		default:
+			return []string{start, end, limit, reverse}, next, nil
+		}
+		next = j
+	}
+	return []string{start, end, limit, reverse}, next, nil
+}
+
+// getCursor parses ` <cursor>` after SCAN CURSOR
+func (p *Parser) getCursor(i int) ([]string, int, error) {
+	next, err := p.dropSpaces(i)
+	if err != nil {
+		return nil, next, err
+	}
+
+	cursor, next, _ := p.getChars(next, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsLower(r)
+	})
+	if cursor == "" {
+		return nil, next, fmt.Errorf("a cursor needed here:\n%s", p.errorOn(next))
+	}
+	return []string{cursor}, next, nil
+}
+
+// getRequestOptions parses the optional `<lock>` and priority of a request
+func (p *Parser) getRequestOptions(i int) ([]string, int, error) {
+	var options []string
+	hasLock, hasPriority := false, false
+	for i < p.Length {
+		j, err := p.dropSpaces(i)
+		if err != nil {
+			break
+		}
+		option, j, _ := p.getChars(j, unicode.IsLetter)
+
+		switch option = strings.ToUpper(option); {
+		case option == "NOWAIT" && !hasLock:
+			hasLock = true
+			options = append(options, option)
+		case (option == "TIMEOUT" && !hasLock) || (option == "PRIORITY" && !hasPriority):
+			if j, err = p.dropSpaces(j); err != nil {
+				return nil, j, err
+			}
+			start := j
+			if j < p.Length && p.Input[j] == '-' && option == "PRIORITY" {
+				j++
+			}
+			var number string
+			if number, j, _ = p.getChars(j, unicode.IsDigit); number == "" {
+				return nil, j, fmt.Errorf("a number needed here:\n%s", p.errorOn(j))
+			}
+			hasLock = hasLock || option == "TIMEOUT"
+			hasPriority = hasPriority || option == "PRIORITY"
+			options = append(options, option, p.Input[start:j])
+		default:
+			return options, i, nil
+		}
+		i = j
+	}
+	return options, i, nil
+}
+
 func (p *Parser) dropSpaces(i int) (int, error) {
 	if i >= p.Length {
 		return i, fmt.Errorf("should not be terminiated here:\n%s", p.errorOn(i))
@@ -0,0 +1,99 @@
+package parsers
+
+import (
+	"reflect"
+	"simple-kv/pkg/protos"
+	"testing"
+)
+
+func TestParser_Parse(t *testing.T) {
+	tests := []struct {
+		input   string
+		t       protos.CommandType
+		payload []string
+	}{
+		{`GET "a"`, protos.Get, []string{"a"}},
+		{`  get   "a b"  `, protos.Get, []string{"a b"}},
+		{`PUT "a" "1"`, protos.Put, []string{"a", "1"}},
+		{`DEL "a"`, protos.Del, []string{"a"}},
+		{`SCAN "a" 10`, protos.Scan, []string{"a", "10"}},
+		{`SCAN "a" "z"`, protos.Range, []string{"a", "z", "0", "0"}},
+		{`SCAN "a" "z" LIMIT 5 REVERSE`, protos.Range, []string{"a", "z", "5", "1"}},
+		{`SCAN "a" "z" REVERSE LIMIT 5`, protos.Range, []string{"a", "z", "5", "1"}},
+		{`SCAN CURSOR 1a2b`, protos.Fetch, []string{"1a2b"}},
+		{`PSCAN "user:"`, protos.PScan, []string{"user:", "0"}},
+		{`PSCAN "user:" 20`, protos.PScan, []string{"user:", "20"}},
+		{`BEGIN`, protos.Begin, nil},
+		{`BEGIN NOWAIT PRIORITY 3`, protos.Begin, []string{"NOWAIT", "PRIORITY", "3"}},
+		{`COMMIT`, protos.Commit, nil},
+		{`ABORT`, protos.Abort, nil},
+		{`PUT "a" "1" TIMEOUT 100`, protos.Put, []string{"a", "1", "TIMEOUT", "100"}},
+		{`GET "a" priority -2 nowait`, protos.Get, []string{"a", "PRIORITY", "-2", "NOWAIT"}},
+		{`SCAN "a" "z" LIMIT 5 NOWAIT`, protos.Range, []string{"a", "z", "5", "0", "NOWAIT"}},
+		{`SHOW deadlocks`, protos.Show, []string{"DEADLOCKS"}},
+	}
+
+	for _, test := range tests {
+		req, err := NewParser().Parse(test.input)
+		if err != nil {
+			t.Errorf("%s: Expect nil, got %v\n", test.input, err)
+			continue
+		}
+		if req.Type != test.t || !reflect.DeepEqual(req.Payload, test.payload) {
+			t.Errorf("%s: Expect %v %q, got %v %q\n", test.input, test.t, test.payload, req.Type, req.Payload)
+		}
+	}
+}
+
+func TestParser_Invalid(t *testing.T) {
+	tests := []string{
+		``,
+		`FOO "a"`,
+		// wrong argument counts
+		`GET`,
+		`GET "a" "b"`,
+		`PUT "a"`,
+		`PUT "a" "1" "2"`,
+		`DEL`,
+		`SCAN`,
+		`SCAN "a"`,
+		`SCAN CURSOR`,
+		`PSCAN`,
+		`BEGIN "a"`,
+		`SHOW`,
+		`SHOW LOCKS`,
+		// malformed strings and numbers
+		`GET a`,
+		`GET "a`,
+		`PUT "a""1"`,
+		`SCAN "a" x`,
+		`SCAN "a" 10x`,
+		`SCAN "a" -1`,
+		`SCAN "a" "z" LIMIT`,
+		`SCAN "a" "z" LIMIT x`,
+		`SCAN CURSOR ABC`,
+		`PSCAN "a" x`,
+		`GET "a" TIMEOUT`,
+		`GET "a" TIMEOUT -1`,
+		`GET "a" PRIORITY x`,
+		// unknown or repeated options
+		`GET "a" FOO`,
+		`SCAN "a" "z" LIMIT 5 FOO`,
+		`GET "a" NOWAIT NOWAIT`,
+		`GET "a" NOWAIT TIMEOUT 10`,
+		`GET "a" PRIORITY 1 PRIORITY 2`,
+	}
+
+	for _, input := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%s: Expect error, got panic %v\n", input, r)
+				}
+			}()
+			if req, err := NewParser().Parse(input); err == nil {
+				t.Errorf("%s: Expect error, got %v %q\n", input, req.Type, req.Payload)
+			}
+		}()
+	}
+}
@@ -2,33 +2,63 @@ package protos
 
 import (
 	"encoding/binary"
-	"fmt"
-	"net"
+	"io"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
+	"strconv"
 	"strings"
 )
 
 type CommandType byte
 
+// The values are sent over the wire, so they must never change: a new type
+// takes the next free value, and Invalid stays above all of them.
 const (
-	Get CommandType = iota
-	Put
-	Del
-	Scan
-	Begin
-	Commit
-	Abort
-
-	None
-	Error
-	String
-	Strings
-
-	Invalid
+	// Hello opens a connection, see handshake.go
+	Hello CommandType = 0
+	Get   CommandType = 1
+	Put   CommandType = 2
+	Del   CommandType = 3
+	Scan  CommandType = 4
+	// Range is SCAN over [start, end) with limit and direction
+	Range CommandType = 5
+	PScan CommandType = 6
+	// Fetch reads the next page of a scan cursor, it is SCAN CURSOR <token> of
+	// the CLI and has no keyword of its own
+	Fetch  CommandType = 7
+	Begin  CommandType = 8
+	Commit CommandType = 9
+	Abort  CommandType = 10
+
+	None    CommandType = 11
+	Error   CommandType = 12
+	String  CommandType = 13
+	Strings CommandType = 14
+	// Chunk is a part of a streaming response, with keys and values in turn
+	Chunk CommandType = 15
+	// End closes a streaming response, with the cursor token of the next page,
+	// the token is empty if there is no next page
+	End CommandType = 16
+	// Nil is the value of a key which does not exist
+	Nil CommandType = 17
+	// Show is an admin request, SHOW DEADLOCKS returns the recent deadlocks as Strings
+	Show CommandType = 18
+
+	Invalid CommandType = 19
 )
-const CommandHeaderLength = 9
+
+// CommandHeaderLength is the length of <payload length:8><type:1><request id:8>
+const CommandHeaderLength = 17
+
+// ConnectionID is the request ID never chosen by clients. An Error frame with it
+// fails the connection rather than a request, e.g. on a frame too large to read,
+// and the connection is closed after it.
+const ConnectionID uint64 = 0
 
 func ToCommandType(t string) CommandType {
 	switch strings.ToUpper(t) {
+	case "HELLO":
+		return Hello
 	case "GET":
 		return Get
 	case "PUT":
@@ -37,6 +67,10 @@ func ToCommandType(t string) CommandType {
 		return Del
 	case "SCAN":
 		return Scan
+	case "RANGE":
+		return Range
+	case "PSCAN":
+		return PScan
 	case "BEGIN":
 		return Begin
 	case "COMMIT":
@@ -51,6 +85,14 @@ func ToCommandType(t string) CommandType {
 		return String
 	case "STRINGS":
 		return Strings
+	case "CHUNK":
+		return Chunk
+	case "END":
+		return End
+	case "NIL":
+		return Nil
+	case "SHOW":
+		return Show
 	default:
 		return Invalid
 	}
@@ -59,7 +101,9 @@ func ToCommandType(t string) CommandType {
 type Command struct {
 	PayloadLength uint64
 	Type          CommandType
-	Payload       []string
+	// ID is chosen by the client for a request, and every frame of its response carries the same ID
+	ID      uint64
+	Payload []string
 }
 
 func NewCommand(t CommandType, payload []string) *Command {
@@ -70,8 +114,9 @@ func NewCommand(t CommandType, payload []string) *Command {
 	}
 }
 
+// NewErrorCommand carries the message and the code of `err`
 func NewErrorCommand(err error) *Command {
-	payload := []string{err.Error()}
+	payload := []string{err.Error(), strconv.Itoa(int(errs.CodeOf(err)))}
 	return &Command{
 		PayloadLength: calcPayloadLength(payload),
 		Type:          Error,
@@ -79,34 +124,57 @@ func NewErrorCommand(err error) *Command {
 	}
 }
 
-func ParseCommand(conn net.Conn) (*Command, error) {
+// ToError converts an Error frame to the error sent by the peer
+func ToError(c *Command) *errs.Error {
+	message, code := "", errs.Unknown
+	if len(c.Payload) > 0 {
+		message = c.Payload[0]
+	}
+	if len(c.Payload) > 1 {
+		if n, err := strconv.Atoi(c.Payload[1]); err == nil {
+			code = errs.Code(n)
+		}
+	}
+	return errs.New(code, "%s", message)
+}
+
+// ParseCommand reads exactly one frame from the connection.
+// io.EOF is returned only if the connection is closed between frames.
+// The command is returned with a non-fatal protocol error as well, so that the
+// error can be answered with the request ID.
+func ParseCommand(conn io.Reader) (*Command, error) {
 	header := make([]byte, CommandHeaderLength)
-	n, err := conn.Read(header)
-	if err != nil {
+	if _, err := io.ReadFull(conn, header); err != nil {
 		return nil, err
 	}
-	if n <= 8 {
-		return nil, fmt.Errorf("invalid message received: n=%d", n)
-	}
 
 	command := &Command{
 		PayloadLength: binary.BigEndian.Uint64(header),
 		Type:          CommandType(header[8]),
+		ID:            binary.BigEndian.Uint64(header[9:]),
 	}
-	if command.Type >= Invalid {
-		return nil, fmt.Errorf("invalid command type: type=%v", command.Type)
+	if command.PayloadLength > config.MaxFrameSize {
+		// the payload is not consumed, so the stream can not be synchronized again
+		return nil, NewProtocolError(true, "frame too large: length=%d, max=%d", command.PayloadLength, config.MaxFrameSize)
 	}
 
 	payload := make([]byte, command.PayloadLength)
-	n, err = conn.Read(payload)
-	if err != nil {
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
-	if uint64(n) != command.PayloadLength {
-		return nil, fmt.Errorf("received message content size: expect=%d, got=%d", command.PayloadLength, n)
+
+	if command.Type >= Invalid {
+		return command, NewProtocolError(false, "invalid command type: type=%v", command.Type)
 	}
 
-	command.Payload = parsePayload(payload, command.PayloadLength)
+	var err error
+	command.Payload, err = parsePayload(payload)
+	if err != nil {
+		return command, err
+	}
 	return command, nil
 }
 
@@ -118,14 +186,21 @@ func calcPayloadLength(payload []string) uint64 {
 	return uint64(length)
 }
 
-func parsePayload(buffer []byte, length uint64) []string {
+func parsePayload(buffer []byte) ([]string, error) {
 	var res []string
+	length := uint64(len(buffer))
 	for i := uint64(0); i < length; {
+		if length-i < 8 {
+			return nil, NewProtocolError(false, "truncated string length: offset=%d, length=%d", i, length)
+		}
 		l := binary.BigEndian.Uint64(buffer[i:])
+		if l > length-i-8 {
+			return nil, NewProtocolError(false, "string exceeds the frame: offset=%d, string=%d, length=%d", i, l, length)
+		}
 		res = append(res, string(buffer[i+8:i+8+l]))
 		i += 8 + l
 	}
-	return res
+	return res, nil
 }
 
 func (c *Command) Serialize() []byte {
@@ -133,8 +208,9 @@ func (c *Command) Serialize() []byte {
 	buffer := make([]byte, CommandHeaderLength+c.PayloadLength)
 	binary.BigEndian.PutUint64(buffer, c.PayloadLength)
 	buffer[8] = byte(c.Type)
+	binary.BigEndian.PutUint64(buffer[9:], c.ID)
 
-	i := 9
+	i := CommandHeaderLength
 	for _, payload := range c.Payload {
 		binary.BigEndian.PutUint64(buffer[i:], uint64(len(payload)))
 		copy(buffer[i+8:], payload)
@@ -143,8 +219,12 @@ func (c *Command) Serialize() []byte {
 	return buffer
 }
 
-func (c *Command) Send(conn net.Conn) error {
-	// TODO: retry
-	_, err := conn.Write(c.Serialize())
+func (c *Command) Send(conn io.Writer) error {
+	buffer := c.Serialize()
+	if c.PayloadLength > config.MaxFrameSize {
+		return NewProtocolError(false, "frame too large: length=%d, max=%d", c.PayloadLength, config.MaxFrameSize)
+	}
+
+	_, err := conn.Write(buffer)
 	return err
 }
@@ -0,0 +1,116 @@
+package protos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"simple-kv/pkg/config"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseCommand_Segmented(t *testing.T) {
+	val := strings.Repeat("v", 1<<20)
+	buffer := NewCommand(Put, []string{"key", val}).Serialize()
+
+	command, err := ParseCommand(iotest.OneByteReader(bytes.NewReader(buffer)))
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	if command.Type != Put || len(command.Payload) != 2 || command.Payload[0] != "key" || command.Payload[1] != val {
+		t.Errorf("Expect PUT key with %d bytes, got %v %v\n", len(val), command.Type, len(command.Payload))
+	}
+}
+
+func TestParseCommand_Truncated(t *testing.T) {
+	buffer := NewCommand(Get, []string{"key"}).Serialize()
+
+	if _, err := ParseCommand(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("Expect EOF, got %v\n", err)
+	}
+	for _, n := range []int{4, CommandHeaderLength, len(buffer) - 1} {
+		if _, err := ParseCommand(bytes.NewReader(buffer[:n])); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expect unexpected EOF at %d, got %v\n", n, err)
+		}
+	}
+}
+
+func TestParseCommand_TooLarge(t *testing.T) {
+	header := make([]byte, CommandHeaderLength)
+	binary.BigEndian.PutUint64(header, config.MaxFrameSize+1)
+	header[8] = byte(Put)
+
+	_, err := ParseCommand(bytes.NewReader(header))
+	if protoErr, ok := err.(*ProtocolError); !ok || !protoErr.Fatal {
+		t.Errorf("Expect fatal protocol error, got %v\n", err)
+	}
+
+	err = NewCommand(Put, []string{"key", strings.Repeat("v", int(config.MaxFrameSize))}).Send(io.Discard)
+	if _, ok := err.(*ProtocolError); !ok {
+		t.Errorf("Expect protocol error, got %v\n", err)
+	}
+}
+
+func TestParseCommand_Malformed(t *testing.T) {
+	// the inner string length exceeds the frame
+	malformed := NewCommand(Get, []string{"key"}).Serialize()
+	binary.BigEndian.PutUint64(malformed[CommandHeaderLength:], 1<<62)
+	// the frame ends in the middle of an inner length
+	truncated := NewCommand(Get, nil).Serialize()
+	binary.BigEndian.PutUint64(truncated, 4)
+	truncated = append(truncated, 0, 0, 0, 0)
+	// the type is unknown
+	invalid := NewCommand(Get, []string{"key"}).Serialize()
+	invalid[8] = byte(Invalid)
+
+	var buffer bytes.Buffer
+	for _, frame := range [][]byte{malformed, truncated, invalid} {
+		buffer.Write(frame)
+	}
+	buffer.Write(NewCommand(Get, []string{"next"}).Serialize())
+
+	for i := 0; i < 3; i++ {
+		_, err := ParseCommand(&buffer)
+		if protoErr, ok := err.(*ProtocolError); !ok || protoErr.Fatal {
+			t.Errorf("Expect non-fatal protocol error, got %v\n", err)
+		}
+	}
+
+	// the stream is still in sync after the malformed frames
+	command, err := ParseCommand(&buffer)
+	if err != nil || command.Type != Get || command.Payload[0] != "next" {
+		t.Errorf("Expect GET next, got %v %v\n", command, err)
+	}
+}
+
+func TestParseCommand_ID(t *testing.T) {
+	req := NewCommand(Get, []string{"key"})
+	req.ID = 42
+
+	command, err := ParseCommand(bytes.NewReader(req.Serialize()))
+	if err != nil || command.ID != 42 {
+		t.Errorf("Expect ID 42, got %v %v\n", command, err)
+	}
+
+	invalid := req.Serialize()
+	invalid[8] = byte(Invalid)
+	command, err = ParseCommand(bytes.NewReader(invalid))
+	if err == nil || command == nil || command.ID != 42 {
+		t.Errorf("Expect ID 42 with an error, got %v %v\n", command, err)
+	}
+}
+
+func TestCommandType_Wire(t *testing.T) {
+	// the values are part of the protocol, a new type must not move the others
+	types := []CommandType{Hello, Get, Put, Del, Scan, Range, PScan, Fetch, Begin, Commit, Abort,
+		None, Error, String, Strings, Chunk, End, Nil, Show, Invalid}
+	for value, typ := range types {
+		if typ != CommandType(value) {
+			t.Errorf("Expect %d, got %d\n", value, typ)
+		}
+		if buffer := NewCommand(typ, nil).Serialize(); buffer[8] != byte(value) {
+			t.Errorf("Expect the type byte %d, got %d\n", value, buffer[8])
+		}
+	}
+}
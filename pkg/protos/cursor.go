@@ -0,0 +1,48 @@
+package protos
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"simple-kv/pkg/txns"
+)
+
+// Cursor is the rest of a scan to be fetched page by page in the same transaction,
+// so that every page is read from the same MVCC snapshot
+type Cursor struct {
+	Token string
+	Txn   *txns.Txn
+	Start string
+	End   string
+	// Remaining is the count of records still to return, 0 is unlimited
+	Remaining int
+	Reverse   bool
+}
+
+func NewCursor(txn *txns.Txn, start string, end string, limit int, reverse bool) *Cursor {
+	return &Cursor{
+		Txn:       txn,
+		Start:     start,
+		End:       end,
+		Remaining: limit,
+		Reverse:   reverse,
+	}
+}
+
+// Advance moves the cursor past the page ending with `lastKey`
+func (c *Cursor) Advance(lastKey string, count int) {
+	if c.Reverse {
+		c.End = lastKey
+	} else {
+		// the smallest key greater than the last one
+		c.Start = lastKey + "\x00"
+	}
+	if c.Remaining > 0 {
+		c.Remaining -= count
+	}
+}
+
+func newToken() string {
+	buffer := make([]byte, 16)
+	_, _ = rand.Read(buffer)
+	return hex.EncodeToString(buffer)
+}
@@ -0,0 +1,28 @@
+package protos
+
+import (
+	"fmt"
+	"simple-kv/pkg/errs"
+)
+
+// ProtocolError is a malformed frame received or to be sent.
+// If it is fatal, the frame has not been consumed and the connection must be closed.
+type ProtocolError struct {
+	Fatal  bool
+	Reason string
+}
+
+func NewProtocolError(fatal bool, format string, args ...any) *ProtocolError {
+	return &ProtocolError{
+		Fatal:  fatal,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+func (e *ProtocolError) Error() string {
+	return "protocol error: " + e.Reason
+}
+
+func (e *ProtocolError) Code() errs.Code {
+	return errs.Protocol
+}
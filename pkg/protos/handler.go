@@ -1,23 +1,46 @@
 package protos
 
 import (
-	"fmt"
+	"bufio"
 	"io"
 	"net"
+	"simple-kv/pkg/config"
 	"simple-kv/pkg/engines"
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/index"
 	"simple-kv/pkg/logger"
+	"simple-kv/pkg/txns"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 type Handler struct {
 	engine  *engines.StringEngine
 	session *Session
+	writer  *bufio.Writer
+	// agreed is the handshake answered to the client, which is of the latest
+	// version until the handshake is done
+	agreed *Handshake
+	// client is the address of the client, to report deadlocks
+	client string
+
+	// latch guards the states below, which are read by the server on shutdown
+	latch sync.Mutex
+	conn  net.Conn
+	// reading is true while waiting for the next request
+	reading  bool
+	inTxn    bool
+	draining bool
+	killed   bool
 }
 
 func NewHandler(engine *engines.StringEngine) *Handler {
 	return &Handler{
 		engine:  engine,
 		session: NewSession(),
+		agreed:  NewHandshake(ServerName),
 	}
 }
 
@@ -28,22 +51,62 @@ func (h *Handler) Handle(conn net.Conn) {
 		err  error
 	)
 
-	for {
-		req, err = ParseCommand(conn)
+	h.latch.Lock()
+	h.conn = conn
+	h.reading = true
+	if h.killed {
+		_ = conn.Close()
+	}
+	h.latch.Unlock()
+
+	h.client = conn.RemoteAddr().String()
+	reader := bufio.NewReader(conn)
+	h.writer = bufio.NewWriter(conn)
+	if err = h.handshake(reader); err != nil {
+		logger.Inst.Warnw("fail to handshake",
+			"addr", conn.RemoteAddr(),
+			"err", err)
+		_ = NewErrorCommand(err).Send(h.writer)
+		_ = h.writer.Flush()
+		_ = conn.Close()
+		return
+	}
+
+	for h.await(reader.Buffered()) {
+		req, err = ParseCommand(reader)
+		h.latch.Lock()
+		h.reading = false
+		busy := h.draining && !h.inTxn
+		h.latch.Unlock()
 		if err != nil {
-			if err == io.EOF {
+			protoErr, ok := err.(*ProtocolError)
+			if !ok {
 				logger.Inst.Warn("connection closed",
 					"command", req,
 					"err", err)
-				_ = conn.Close()
 				break
-			} else {
-				logger.Inst.Warn("fail to parse command",
-					"command", req,
-					"err", err)
+			}
+
+			logger.Inst.Warnw("fail to parse command",
+				"err", err)
+			if protoErr.Fatal {
 				resp = NewErrorCommand(err)
+				resp.ID = ConnectionID
+				_ = resp.Send(h.writer)
+				break
 			}
+			resp = NewErrorCommand(err)
+		} else if busy {
+			resp = NewErrorCommand(errs.New(errs.ServerBusy, "server is shutting down"))
 		} else {
+			// a request may wait for locks, so the responses of the requests
+			// before it should not wait with it
+			if mayBlock(req) && h.writer.Buffered() > 0 {
+				if err = h.writer.Flush(); err != nil {
+					logger.Inst.Warnw("fail to flush responses",
+						"err", err)
+				}
+			}
 			resp, err = h.Execute(req)
 			if err != nil {
 				logger.Inst.Warn("fail to execute command",
@@ -53,29 +116,189 @@ func (h *Handler) Handle(conn net.Conn) {
 			}
 		}
 
-		err = resp.Send(conn)
+		if req != nil {
+			resp.ID = req.ID
+		}
+		err = resp.Send(h.writer)
 		if err != nil {
 			logger.Inst.Warn("fail to send command",
 				"req", req,
 				"resp", resp,
 				"err", err)
 		}
+
+		// responses of pipelined requests are flushed together
+		if !h.agreed.Has(FeaturePipelining) || reader.Buffered() == 0 {
+			if err = h.writer.Flush(); err != nil {
+				logger.Inst.Warnw("fail to flush responses",
+					"err", err)
+			}
+		}
+
+		h.latch.Lock()
+		h.inTxn = h.session.GetTxn() != nil
+		h.latch.Unlock()
+	}
+
+	_ = h.writer.Flush()
+	_ = conn.Close()
+	h.Close()
+}
+
+// mayBlock tells whether the request may wait for locks or the disk
+func mayBlock(req *Command) bool {
+	switch req.Type {
+	case Get, Put, Del, Scan, Range, PScan, Fetch, Commit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close aborts the transaction left open by the client, releasing its locks
+func (h *Handler) Close() {
+	txn := h.session.GetTxn()
+	if txn == nil {
+		return
+	}
+
+	if txn.State == txns.Processing {
+		_ = txn.Abort()
+		logger.Inst.Infow("abort the transaction of the closed session",
+			"txn", txn.ID)
+	}
+	h.session.SetTxn(nil)
+}
+
+// await tells whether to read the next request, which is not the case once the
+// handler is draining and no transaction is left open. The requests already
+// received by then are still read, to answer them with SERVER_BUSY.
+func (h *Handler) await(buffered int) bool {
+	h.latch.Lock()
+	defer h.latch.Unlock()
+
+	if h.draining && !h.inTxn {
+		if buffered == 0 {
+			return false
+		}
+		// read the buffered requests without waiting for more
+		_ = h.conn.SetReadDeadline(time.Now())
+	}
+	h.reading = true
+	return true
+}
+
+// Drain lets the session finish its open transaction and then closes the
+// connection, or closes it at once if the session is idle. It tells whether
+// a transaction is open.
+func (h *Handler) Drain() bool {
+	h.latch.Lock()
+	defer h.latch.Unlock()
+
+	h.draining = true
+	if h.conn != nil && h.reading && !h.inTxn {
+		// wake up the pending read
+		_ = h.conn.SetReadDeadline(time.Now())
+	}
+	return h.inTxn
+}
+
+// Kill closes the connection at once, then the open transaction is aborted once
+// the handler stops. It tells whether a transaction is open.
+func (h *Handler) Kill() bool {
+	h.latch.Lock()
+	defer h.latch.Unlock()
+
+	h.draining = true
+	h.killed = true
+	if h.conn != nil {
+		_ = h.conn.Close()
+	}
+	return h.inTxn
+}
+
+// handshake reads the HELLO frame of the client and answers it
+func (h *Handler) handshake(reader io.Reader) error {
+	req, err := ParseCommand(reader)
+	if err != nil {
+		return err
+	}
+	client, err := ParseHandshake(req)
+	if err != nil {
+		return err
+	}
+	h.agreed, err = Negotiate(client)
+	if err != nil {
+		return err
 	}
+
+	resp := h.agreed.Command()
+	resp.ID = req.ID
+	if err = resp.Send(h.writer); err != nil {
+		return err
+	}
+	logger.Inst.Infow("client connected",
+		"client", client.Name,
+		"version", h.agreed.Version,
+		"features", h.agreed.Features)
+	return h.writer.Flush()
 }
 
 func (h *Handler) Execute(req *Command) (resp *Command, err error) {
-	isLocalTxn := h.session.GetTxn() == nil && req.Type != Begin
+	if err = validate(req, h.agreed.Version); err != nil {
+		return nil, err
+	}
+	if req.Type == Show {
+		return h.show(req.Payload[0])
+	}
+
+	// a session is either idle or in a transaction: BEGIN is only allowed when
+	// idle, and COMMIT/ABORT only in a transaction, which they always end
+	open := h.session.GetTxn() != nil
+	switch {
+	case req.Type == Begin && open:
+		return nil, errs.New(errs.TxnActive, "transaction in progress: txn=%d", h.session.GetTxn().ID)
+	case (req.Type == Commit || req.Type == Abort) && !open:
+		return nil, errs.New(errs.TxnNotActive, "no transaction in progress")
+	}
+
+	opts, _ := parseOptions(req.Payload[requestArity[req.Type]:])
+	if opts.hasPriority && open {
+		return nil, errs.New(errs.InvalidArgument, "priority of a transaction in progress can not be changed: txn=%d", h.session.GetTxn().ID)
+	}
+
+	isLocalTxn := !open && req.Type != Begin
 	if isLocalTxn {
-		h.session.SetTxn(h.engine.NewTxn())
+		h.session.SetTxn(h.newTxn())
 	}
 
 	resp = &Command{}
 	txn := h.session.GetTxn()
+	if opts.hasLockTimeout && req.Type != Begin {
+		defer func(timeout time.Duration) {
+			txn.LockTimeout = timeout
+		}(txn.LockTimeout)
+		txn.LockTimeout = opts.lockTimeout
+	}
+	if opts.hasPriority && isLocalTxn {
+		txn.Priority = opts.priority
+	}
+
 	switch req.Type {
 	case Get:
-		resp.Type = String
-		resp.Payload = make([]string, 1)
-		resp.Payload[0], err = h.engine.Get(txn, req.Payload[0])
+		var (
+			val   string
+			found bool
+		)
+		val, found, err = h.engine.Get(txn, req.Payload[0])
+		switch {
+		case found:
+			resp = NewCommand(String, []string{val})
+		case err == nil && h.agreed.Version < NilVersion:
+			err = errs.New(errs.NoSuchKey, "no such key: %q", req.Payload[0])
+		default:
+			resp = NewCommand(Nil, nil)
+		}
 
 	case Put:
 		resp.Type = None
@@ -86,39 +309,178 @@ func (h *Handler) Execute(req *Command) (resp *Command, err error) {
 		err = h.engine.Del(txn, req.Payload[0])
 
 	case Scan:
-		count, err := strconv.Atoi(req.Payload[1])
+		var count int
+		count, err = parseNumber(req.Payload[1])
 		if err != nil {
 			break
 		}
+		if count <= 0 {
+			resp = NewCommand(End, []string{""})
+			break
+		}
+		resp, err = h.page(req.ID, NewCursor(txn, req.Payload[0], "", count, false), isLocalTxn)
 
-		resp.Type = Strings
-		resp.Payload, err = h.engine.Scan(txn, req.Payload[0], count)
+	case Range:
+		var limit int
+		limit, err = parseNumber(req.Payload[2])
+		if err != nil {
+			break
+		}
+		resp, err = h.page(req.ID, NewCursor(txn, req.Payload[0], req.Payload[1], limit, req.Payload[3] == "1"), isLocalTxn)
+
+	case PScan:
+		var count int
+		count, err = parseNumber(req.Payload[1])
+		if err != nil {
+			break
+		}
+		resp, err = h.page(req.ID, NewCursor(txn, req.Payload[0], index.PrefixEnd(req.Payload[0]), count, false), isLocalTxn)
+
+	case Fetch:
+		cursor := h.session.GetCursor(req.Payload[0])
+		if cursor == nil || cursor.Txn != txn {
+			err = errs.New(errs.InvalidArgument, "no such cursor in the transaction: cursor=%s", req.Payload[0])
+			break
+		}
+		resp, err = h.page(req.ID, cursor, isLocalTxn)
 
 	case Begin:
-		h.session.SetTxn(h.engine.NewTxn())
+		txn = h.newTxn()
+		if opts.hasLockTimeout {
+			txn.LockTimeout = opts.lockTimeout
+		}
+		if opts.hasPriority {
+			txn.Priority = opts.priority
+		}
+		h.session.SetTxn(txn)
 		resp.Type = None
 
 	case Commit:
-		err = h.session.GetTxn().Commit()
-		if err != nil {
-			h.session.SetTxn(nil)
-			resp.Type = None
-		}
+		resp.Type = None
+		err = txn.Commit()
+		h.session.SetTxn(nil)
 
 	case Abort:
-		err = h.session.GetTxn().Abort()
-		if err != nil {
-			h.session.SetTxn(nil)
-			resp.Type = None
+		resp.Type = None
+		// the txn may have been aborted as a deadlock victim
+		if txn.State == txns.Processing {
+			err = txn.Abort()
 		}
+		h.session.SetTxn(nil)
 
 	default:
-		err = fmt.Errorf("invalid command type: type=%v", req.Type)
+		err = NewProtocolError(false, "invalid command type: type=%v", req.Type)
 	}
 
 	if isLocalTxn {
-		err = h.session.GetTxn().Commit()
+		if err != nil {
+			_ = txn.Abort()
+		} else {
+			err = txn.Commit()
+		}
 		h.session.SetTxn(nil)
 	}
 	return resp, err
 }
+
+func (h *Handler) newTxn() *txns.Txn {
+	txn := h.engine.NewTxn()
+	txn.Client = h.client
+	return txn
+}
+
+// show answers the admin request SHOW, which runs outside any transaction
+func (h *Handler) show(subject string) (*Command, error) {
+	switch strings.ToUpper(subject) {
+	case "DEADLOCKS":
+		reports := h.engine.Detector.Reports()
+		lines := make([]string, 0, len(reports))
+		for _, report := range reports {
+			lines = append(lines, report.String())
+		}
+		return NewCommand(Strings, lines), nil
+	default:
+		return nil, errs.New(errs.InvalidArgument, "nothing to show: subject=%q", subject)
+	}
+}
+
+// page streams the next page of the cursor as Chunk frames and returns the End frame.
+// In an explicit transaction, if there are more records, the cursor is kept in
+// the session and its token is returned with the End frame. A local transaction
+// ends with the request, so the whole scan is streamed page by page instead, and
+// every page is read from the same snapshot either way.
+func (h *Handler) page(id uint64, cursor *Cursor, isLocalTxn bool) (*Command, error) {
+	writer := NewStreamWriter(h.writer, id, config.ScanChunkSize)
+	for {
+		size := config.ScanPageSize
+		if cursor.Remaining > 0 && cursor.Remaining < size {
+			size = cursor.Remaining
+		}
+
+		// read one more record to know whether there is a next page
+		pairs, err := h.engine.Range(cursor.Txn, cursor.Start, cursor.End, size+1, cursor.Reverse)
+		if err != nil {
+			return nil, err
+		}
+
+		more := len(pairs) > size && cursor.Remaining != size
+		if len(pairs) > size {
+			pairs = pairs[:size]
+		}
+		for _, pair := range pairs {
+			if err = writer.Write(pair.Key, pair.Val); err != nil {
+				return nil, err
+			}
+		}
+
+		if !more {
+			if cursor.Token != "" {
+				h.session.DelCursor(cursor.Token)
+			}
+			return writer.End("")
+		}
+		cursor.Advance(pairs[len(pairs)-1].Key, len(pairs))
+		if !isLocalTxn {
+			return writer.End(h.session.SaveCursor(cursor))
+		}
+	}
+}
+
+func parseNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errs.New(errs.InvalidArgument, "invalid number: number=%q", s)
+	}
+	return n, nil
+}
+
+// requestArity is the payload length of every request type without options
+var requestArity = map[CommandType]int{
+	Get:    1,
+	Put:    2,
+	Del:    1,
+	Scan:   2,
+	Range:  4,
+	PScan:  2,
+	Fetch:  1,
+	Begin:  0,
+	Commit: 0,
+	Abort:  0,
+	Show:   1,
+}
+
+// validate checks the request against the protocol `version` agreed on
+func validate(req *Command, version int) error {
+	if req.Type == Hello {
+		return NewProtocolError(false, "handshake has been done")
+	}
+	arity, ok := requestArity[req.Type]
+	if !ok || (req.Type == Show && version < NilVersion) {
+		return NewProtocolError(false, "not a request: type=%v, version=%d", req.Type, version)
+	}
+	if len(req.Payload) < arity || (len(req.Payload) > arity && version < NilVersion) {
+		return NewProtocolError(false, "invalid payload: type=%v, expect=%d, got=%d", req.Type, arity, len(req.Payload))
+	}
+	_, err := parseOptions(req.Payload[arity:])
+	return err
+}
@@ -0,0 +1,179 @@
+package protos
+
+import (
+	"net"
+	"simple-kv/pkg/engines"
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/txns"
+	"testing"
+	"time"
+)
+
+func TestHandler_Session(t *testing.T) {
+	engine := engines.NewStringEngine().Run()
+	defer engine.Stop()
+	h := NewHandler(engine)
+
+	if _, err := h.Execute(NewCommand(Commit, nil)); !errs.Is(err, errs.TxnNotActive) {
+		t.Errorf("Expect %v, got %v\n", errs.TxnNotActive, err)
+	}
+	if _, err := h.Execute(NewCommand(Abort, nil)); !errs.Is(err, errs.TxnNotActive) {
+		t.Errorf("Expect %v, got %v\n", errs.TxnNotActive, err)
+	}
+
+	_, _ = h.Execute(NewCommand(Begin, nil))
+	txn := h.session.GetTxn()
+	if _, err := h.Execute(NewCommand(Begin, nil)); !errs.Is(err, errs.TxnActive) {
+		t.Errorf("Expect %v, got %v\n", errs.TxnActive, err)
+	}
+	if h.session.GetTxn() != txn {
+		t.Errorf("Expect the txn kept after a nested BEGIN\n")
+	}
+
+	// the txn ends even if the commit fails
+	_ = txn.Abort()
+	if _, err := h.Execute(NewCommand(Commit, nil)); !errs.Is(err, errs.TxnNotActive) {
+		t.Errorf("Expect %v, got %v\n", errs.TxnNotActive, err)
+	}
+	if h.session.GetTxn() != nil {
+		t.Errorf("Expect no txn after COMMIT\n")
+	}
+
+	// aborting a txn aborted by others is fine
+	_, _ = h.Execute(NewCommand(Begin, nil))
+	_ = h.session.GetTxn().Abort()
+	if _, err := h.Execute(NewCommand(Abort, nil)); err != nil || h.session.GetTxn() != nil {
+		t.Errorf("Expect no txn after ABORT, got %v\n", err)
+	}
+}
+
+func TestHandler_Close(t *testing.T) {
+	engine := engines.NewStringEngine().Run()
+	defer engine.Stop()
+	server, client := net.Pipe()
+	go NewHandler(engine).Handle(server)
+
+	requests := []*Command{NewHandshake("test").Command(), NewCommand(Begin, nil), NewCommand(Put, []string{"A", "1"})}
+	for _, req := range requests {
+		if err := req.Send(client); err != nil {
+			t.Fatalf("Expect nil, got %v\n", err)
+		}
+		if resp, err := ParseCommand(client); err != nil || resp.Type == Error {
+			t.Fatalf("Expect reply, got %v (err=%v)\n", resp, err)
+		}
+	}
+	_ = client.Close()
+
+	// the lock of the dropped session is released
+	done := make(chan error)
+	go func() {
+		txn := engine.NewTxn()
+		err := engine.Put(txn, "A", "2")
+		if err == nil {
+			err = txn.Commit()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expect nil, got %v\n", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expect the lock released after the connection closed\n")
+	}
+}
+
+func TestHandler_LockOptions(t *testing.T) {
+	engine := engines.NewStringEngine().Run()
+	defer engine.Stop()
+	h1, h2 := NewHandler(engine), NewHandler(engine)
+
+	_, _ = h1.Execute(NewCommand(Begin, nil))
+	if _, err := h1.Execute(NewCommand(Put, []string{"A", "1"})); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+
+	if _, err := h2.Execute(NewCommand(Begin, []string{"NOWAIT"})); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	txn := h2.session.GetTxn()
+	if txn.LockTimeout != txns.NoWait {
+		t.Errorf("Expect NOWAIT, got %v\n", txn.LockTimeout)
+	}
+	if _, err := h2.Execute(NewCommand(Put, []string{"A", "2"})); !errs.Is(err, errs.LockTimeout) {
+		t.Errorf("Expect %v, got %v\n", errs.LockTimeout, err)
+	}
+
+	// the options of a request override the ones of the txn for the request only
+	start := time.Now()
+	if _, err := h2.Execute(NewCommand(Put, []string{"A", "2", "TIMEOUT", "50"})); !errs.Is(err, errs.LockTimeout) {
+		t.Errorf("Expect %v, got %v\n", errs.LockTimeout, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expect waiting for 50ms, got %v\n", elapsed)
+	}
+	if txn.LockTimeout != txns.NoWait {
+		t.Errorf("Expect NOWAIT restored, got %v\n", txn.LockTimeout)
+	}
+
+	for _, options := range [][]string{{"WAIT"}, {"TIMEOUT"}, {"TIMEOUT", "-1"}, {"NOWAIT", "1"}} {
+		payload := append([]string{"A"}, options...)
+		if _, err := h2.Execute(NewCommand(Get, payload)); !errs.Is(err, errs.InvalidArgument) {
+			t.Errorf("Expect %v for %q, got %v\n", errs.InvalidArgument, options, err)
+		}
+	}
+	if _, err := h2.Execute(NewCommand(Get, []string{"B", "PRIORITY", "1"})); !errs.Is(err, errs.InvalidArgument) {
+		t.Errorf("Expect %v for PRIORITY in a txn, got %v\n", errs.InvalidArgument, err)
+	}
+	_, _ = h2.Execute(NewCommand(Abort, nil))
+	_, _ = h1.Execute(NewCommand(Commit, nil))
+
+	_, _ = h2.Execute(NewCommand(Begin, []string{"PRIORITY", "-3", "TIMEOUT", "10"}))
+	if txn = h2.session.GetTxn(); txn.Priority != -3 || txn.LockTimeout != 10*time.Millisecond {
+		t.Errorf("Expect priority -3 and timeout 10ms, got %v and %v\n", txn.Priority, txn.LockTimeout)
+	}
+	_, _ = h2.Execute(NewCommand(Abort, nil))
+}
+
+func TestHandler_Show(t *testing.T) {
+	engine := engines.NewStringEngine().Run()
+	defer engine.Stop()
+	h := NewHandler(engine)
+
+	resp, err := h.Execute(NewCommand(Show, []string{"deadlocks"}))
+	if err != nil || resp.Type != Strings || len(resp.Payload) != 0 {
+		t.Errorf("Expect no deadlock, got %v (err=%v)\n", resp, err)
+	}
+	if h.session.GetTxn() != nil {
+		t.Errorf("Expect no txn after SHOW\n")
+	}
+	if _, err = h.Execute(NewCommand(Show, []string{"LOCKS"})); !errs.Is(err, errs.InvalidArgument) {
+		t.Errorf("Expect %v, got %v\n", errs.InvalidArgument, err)
+	}
+}
+
+func TestHandler_Version1(t *testing.T) {
+	engine := engines.NewStringEngine().Run()
+	defer engine.Stop()
+	h := NewHandler(engine)
+	h.agreed = &Handshake{Version: 1, Name: ServerName}
+
+	// a missing key is answered the way of version 1
+	if _, err := h.Execute(NewCommand(Get, []string{"A"})); !errs.Is(err, errs.NoSuchKey) {
+		t.Errorf("Expect %v, got %v\n", errs.NoSuchKey, err)
+	}
+	for _, req := range []*Command{
+		NewCommand(Get, []string{"A", "NOWAIT"}),
+		NewCommand(Begin, []string{"PRIORITY", "1"}),
+		NewCommand(Show, []string{"DEADLOCKS"}),
+	} {
+		if _, err := h.Execute(req); !errs.Is(err, errs.Protocol) {
+			t.Errorf("Expect a protocol error for %v, got %v\n", req, err)
+		}
+	}
+	if h.session.GetTxn() != nil {
+		t.Errorf("Expect no txn after the rejected requests\n")
+	}
+}
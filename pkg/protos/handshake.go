@@ -0,0 +1,114 @@
+package protos
+
+import (
+	"strconv"
+)
+
+/*
+The first frame on a connection must be HELLO, before any other request:
+
+<hello>    := HELLO <version> <name> <feature>*
+<feature>  := pipelining | compression | auth
+
+The server answers with a HELLO frame carrying its own version and name, and the
+features both sides support, or an ERROR frame before closing the connection if
+the versions are not compatible. The layout of HELLO frames must never change.
+
+The version is bumped whenever the frames change, and the server answers an
+older client the way its version does:
+
+1  the first version with the handshake
+2  a missing key is answered with NIL instead of an ERROR with NO_SUCH_KEY,
+   requests may carry options (NOWAIT, TIMEOUT, PRIORITY), and SHOW is added
+*/
+
+const (
+	// ProtocolVersion is the version spoken by this binary
+	ProtocolVersion = 2
+	// MinProtocolVersion is the oldest version still accepted from the peer
+	MinProtocolVersion = 1
+
+	// NilVersion is the first version with NIL, request options and SHOW
+	NilVersion = 2
+
+	ServerName = "simple-kv"
+)
+
+const (
+	FeaturePipelining  = "pipelining"
+	FeatureCompression = "compression"
+	FeatureAuth        = "auth"
+)
+
+// ServerFeatures are the features implemented by the server
+var ServerFeatures = []string{FeaturePipelining}
+
+type Handshake struct {
+	Version  int
+	Name     string
+	Features []string
+}
+
+func NewHandshake(name string, features ...string) *Handshake {
+	return &Handshake{
+		Version:  ProtocolVersion,
+		Name:     name,
+		Features: features,
+	}
+}
+
+func ParseHandshake(command *Command) (*Handshake, error) {
+	if command.Type != Hello {
+		return nil, NewProtocolError(false, "handshake needed before any request: type=%v", command.Type)
+	}
+	if len(command.Payload) < 2 {
+		return nil, NewProtocolError(false, "invalid payload: type=%v, expect>=2, got=%d", command.Type, len(command.Payload))
+	}
+
+	version, err := strconv.Atoi(command.Payload[0])
+	if err != nil {
+		return nil, NewProtocolError(false, "invalid protocol version: version=%q", command.Payload[0])
+	}
+	return &Handshake{
+		Version:  version,
+		Name:     command.Payload[1],
+		Features: command.Payload[2:],
+	}, nil
+}
+
+func (h *Handshake) Command() *Command {
+	payload := append([]string{strconv.Itoa(h.Version), h.Name}, h.Features...)
+	return NewCommand(Hello, payload)
+}
+
+func (h *Handshake) Has(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Negotiate agrees on the version and features with the HELLO of a client,
+// and returns the HELLO answered by the server
+func Negotiate(client *Handshake) (*Handshake, error) {
+	if client.Version < MinProtocolVersion {
+		return nil, NewProtocolError(true, "protocol version not supported: version=%d, min=%d", client.Version, MinProtocolVersion)
+	}
+
+	server := &Handshake{
+		Version: ProtocolVersion,
+		Name:    ServerName,
+	}
+	// a newer client must fall back to the version of the server
+	if client.Version < server.Version {
+		server.Version = client.Version
+	}
+	for _, feature := range ServerFeatures {
+		if client.Has(feature) {
+			server.Features = append(server.Features, feature)
+		}
+	}
+	return server, nil
+}
@@ -0,0 +1,39 @@
+package protos
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	client := NewHandshake("test", FeatureCompression, FeaturePipelining, FeatureAuth)
+	client.Version = ProtocolVersion + 1
+
+	parsed, err := ParseHandshake(client.Command())
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	if !reflect.DeepEqual(parsed, client) {
+		t.Errorf("Expect %v, got %v\n", client, parsed)
+	}
+
+	server, err := Negotiate(parsed)
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	if server.Version != ProtocolVersion || server.Name != ServerName {
+		t.Errorf("Expect version %d of %s, got %v\n", ProtocolVersion, ServerName, server)
+	}
+	if !reflect.DeepEqual(server.Features, []string{FeaturePipelining}) {
+		t.Errorf("Expect only pipelining agreed, got %v\n", server.Features)
+	}
+
+	client.Version = MinProtocolVersion - 1
+	if _, err = Negotiate(client); err == nil {
+		t.Errorf("Expect error, got nil\n")
+	}
+
+	if _, err = ParseHandshake(NewCommand(Get, []string{"key"})); err == nil {
+		t.Errorf("Expect error, got nil\n")
+	}
+}
@@ -0,0 +1,59 @@
+package protos
+
+import (
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/txns"
+	"strings"
+	"time"
+)
+
+/*
+The options of a request follow its arguments in the payload:
+
+<options> := [NOWAIT | TIMEOUT <milliseconds>] [PRIORITY <number>]
+
+The lock options of BEGIN apply to the transaction, and the ones of other
+requests apply to the request only. PRIORITY applies to the transaction begun
+by BEGIN or by a single request.
+*/
+
+type requestOptions struct {
+	lockTimeout    time.Duration
+	hasLockTimeout bool
+	priority       int
+	hasPriority    bool
+}
+
+func parseOptions(options []string) (*requestOptions, error) {
+	opts := &requestOptions{}
+	for i := 0; i < len(options); i++ {
+		option := strings.ToUpper(options[i])
+		switch {
+		case option == "NOWAIT" && !opts.hasLockTimeout:
+			opts.lockTimeout, opts.hasLockTimeout = txns.NoWait, true
+
+		case option == "TIMEOUT" && !opts.hasLockTimeout && i+1 < len(options):
+			i++
+			ms, err := parseNumber(options[i])
+			if err != nil {
+				return nil, err
+			}
+			if ms < 0 {
+				return nil, errs.New(errs.InvalidArgument, "negative lock timeout: timeout=%d", ms)
+			}
+			opts.lockTimeout, opts.hasLockTimeout = time.Duration(ms)*time.Millisecond, true
+
+		case option == "PRIORITY" && !opts.hasPriority && i+1 < len(options):
+			i++
+			priority, err := parseNumber(options[i])
+			if err != nil {
+				return nil, err
+			}
+			opts.priority, opts.hasPriority = priority, true
+
+		default:
+			return nil, errs.New(errs.InvalidArgument, "invalid options: options=%q", options)
+		}
+	}
+	return opts, nil
+}
@@ -1,9 +1,13 @@
 package protos
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"simple-kv/pkg/engines"
+	"simple-kv/pkg/logger"
+	"sync"
+	"time"
 )
 
 type Server struct {
@@ -11,35 +15,177 @@ type Server struct {
 	Port     string
 	Listener net.Listener
 	Engine   *engines.StringEngine
+
+	latch    sync.Mutex
+	handlers map[*Handler]struct{}
+	closing  bool
+	// served is the count of accepted connections
+	served  int
+	running sync.WaitGroup
 }
 
-func NewServer(hostname string, port string) *Server {
+// NewServer recovers the data in `dataDir`, or keeps data in memory only if `dataDir` is empty
+func NewServer(hostname string, port string, dataDir string) (*Server, error) {
+	var engine *engines.StringEngine
+	if dataDir == "" {
+		engine = engines.NewStringEngine()
+	} else {
+		var err error
+		if engine, err = engines.OpenStringEngine(dataDir); err != nil {
+			return nil, err
+		}
+	}
+
 	return &Server{
 		Hostname: hostname,
 		Port:     port,
 		Listener: nil,
-		Engine:   engines.NewStringEngine(),
-	}
+		Engine:   engine,
+		handlers: map[*Handler]struct{}{},
+	}, nil
 }
 
+// Run serves until the server is shut down, then it returns nil
 func (s *Server) Run() (err error) {
 	addr := fmt.Sprintf("%s:%s", s.Hostname, s.Port)
-	s.Listener, err = net.Listen("tcp", addr)
+	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
 
+	s.latch.Lock()
+	s.Listener = listener
+	closing := s.closing
+	s.latch.Unlock()
+	if closing {
+		return listener.Close()
+	}
+
+	s.Engine.Run()
 	for {
-		conn, err := s.Listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
 			return err
 		}
 
 		handler := NewHandler(s.Engine)
-		go handler.Handle(conn)
+		if !s.track(handler) {
+			_ = conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.untrack(handler)
+			handler.Handle(conn)
+		}()
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+	return s.closing
+}
+
+func (s *Server) track(handler *Handler) bool {
+	s.latch.Lock()
+	defer s.latch.Unlock()
+
+	if s.closing {
+		return false
 	}
+	s.handlers[handler] = struct{}{}
+	s.served++
+	s.running.Add(1)
+	return true
+}
+
+func (s *Server) untrack(handler *Handler) {
+	s.latch.Lock()
+	delete(s.handlers, handler)
+	s.latch.Unlock()
+	s.running.Done()
+}
+
+// Shutdown stops accepting connections and closes the idle sessions, then waits
+// for the open transactions to finish until the context is done, after which
+// the remaining connections are closed and their transactions aborted. At last
+// the data is checkpointed and the log is closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	start := time.Now()
+
+	s.latch.Lock()
+	s.closing = true
+	listener := s.Listener
+	handlers := make([]*Handler, 0, len(s.handlers))
+	for handler := range s.handlers {
+		handlers = append(handlers, handler)
+	}
+	served := s.served
+	s.latch.Unlock()
+
+	if listener != nil {
+		_ = listener.Close()
+	}
+
+	open := 0
+	for _, handler := range handlers {
+		if handler.Drain() {
+			open++
+		}
+	}
+	logger.Inst.Infow("server shutting down",
+		"sessions", len(handlers),
+		"open_txns", open)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(stopped)
+	}()
+
+	aborted := 0
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.latch.Lock()
+		for handler := range s.handlers {
+			if handler.Kill() {
+				aborted++
+			}
+		}
+		s.latch.Unlock()
+		<-stopped
+	}
+
+	// the detector is kept until now, since the draining txns may deadlock
+	s.Engine.Stop()
+
+	var err error
+	if s.Engine.Checkpointer != nil {
+		if err = s.Engine.Checkpointer.Checkpoint(); err != nil {
+			logger.Inst.Warnw("fail to checkpoint on shutdown",
+				"err", err)
+		}
+	}
+	if closeErr := s.Engine.Close(); closeErr != nil {
+		err = closeErr
+	}
+
+	logger.Inst.Infow("server shut down",
+		"connections", served,
+		"drained_txns", open-aborted,
+		"aborted_txns", aborted,
+		"elapsed", time.Since(start),
+		"err", err)
+	return err
 }
 
+// Close shuts down the server without waiting for the open transactions
 func (s *Server) Close() error {
-	return s.Listener.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return s.Shutdown(ctx)
 }
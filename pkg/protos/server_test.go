@@ -0,0 +1,243 @@
+package protos
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"simple-kv/pkg/errs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialServer(t *testing.T, addr string) net.Conn {
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	request(t, conn, NewHandshake("test").Command())
+	return conn
+}
+
+func request(t *testing.T, conn net.Conn, req *Command) *Command {
+	if err := req.Send(conn); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	resp, err := ParseCommand(conn)
+	if err != nil || resp.Type == Error {
+		t.Fatalf("Expect reply, got %v (err=%v)\n", resp, err)
+	}
+	return resp
+}
+
+// startServer runs an in-memory server on a free port
+func startServer(t *testing.T) (*Server, string, chan error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	_ = listener.Close()
+
+	server, err := NewServer("localhost", port, "")
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Run()
+	}()
+	return server, net.JoinHostPort("localhost", port), stopped
+}
+
+func TestServer_Deadlock(t *testing.T) {
+	server, addr, _ := startServer(t)
+	defer func() { _ = server.Close() }()
+
+	c1, c2 := dialServer(t, addr), dialServer(t, addr)
+	request(t, c1, NewCommand(Begin, nil))
+	request(t, c2, NewCommand(Begin, nil))
+	request(t, c1, NewCommand(Put, []string{"A", "1"}))
+	request(t, c2, NewCommand(Put, []string{"B", "2"}))
+
+	// both wait for the lock of each other until the detector breaks the cycle
+	for _, req := range []struct {
+		conn net.Conn
+		key  string
+	}{{c1, "B"}, {c2, "A"}} {
+		if err := NewCommand(Put, []string{req.key, "3"}).Send(req.conn); err != nil {
+			t.Fatalf("Expect nil, got %v\n", err)
+		}
+	}
+
+	replies := make(chan *Command, 2)
+	for _, conn := range []net.Conn{c1, c2} {
+		go func(conn net.Conn) {
+			resp, _ := ParseCommand(conn)
+			replies <- resp
+		}(conn)
+	}
+
+	victims := 0
+	var victimErr *errs.Error
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 2; i++ {
+		select {
+		case resp := <-replies:
+			if resp == nil {
+				t.Fatalf("Expect reply, got nil\n")
+			}
+			if resp.Type == Error {
+				victimErr = ToError(resp)
+				if code := victimErr.Code(); code != errs.DeadlockVictim {
+					t.Errorf("Expect %v, got %v\n", errs.DeadlockVictim, code)
+				}
+				victims++
+			}
+		case <-timeout:
+			t.Fatalf("Expect the deadlock broken by the server\n")
+		}
+	}
+	if victims != 1 {
+		t.Fatalf("Expect 1 victim, got %d\n", victims)
+	}
+
+	// the cycle is reported to the victim and kept for SHOW DEADLOCKS
+	cycle := []string{
+		fmt.Sprintf("(%s) waits for write lock on \"B\"", c1.LocalAddr()),
+		fmt.Sprintf("(%s) waits for write lock on \"A\"", c2.LocalAddr()),
+	}
+	for _, step := range cycle {
+		if !strings.Contains(victimErr.Message, step) {
+			t.Errorf("Expect %q in the error, got %q\n", step, victimErr.Message)
+		}
+	}
+	resp := request(t, c1, NewCommand(Show, []string{"DEADLOCKS"}))
+	if resp.Type != Strings || len(resp.Payload) != 1 {
+		t.Fatalf("Expect 1 deadlock, got %v\n", resp)
+	}
+	for _, step := range cycle {
+		if !strings.Contains(resp.Payload[0], step) {
+			t.Errorf("Expect %q in the report, got %q\n", step, resp.Payload[0])
+		}
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	server, addr, stopped := startServer(t)
+
+	idle := dialServer(t, addr)
+	draining := dialServer(t, addr)
+	request(t, draining, NewCommand(Begin, nil))
+	request(t, draining, NewCommand(Put, []string{"A", "1"}))
+	stuck := dialServer(t, addr)
+	request(t, stuck, NewCommand(Begin, nil))
+	request(t, stuck, NewCommand(Put, []string{"B", "1"}))
+
+	timeout := 500 * time.Millisecond
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		done <- server.Shutdown(ctx)
+	}()
+
+	// the idle session is closed at once
+	if _, err := ParseCommand(idle); err != io.EOF {
+		t.Errorf("Expect %v, got %v\n", io.EOF, err)
+	}
+
+	// the open txn may finish, then the session is closed
+	request(t, draining, NewCommand(Put, []string{"A", "2"}))
+
+	// the requests sent after the txn are refused
+	var pipeline bytes.Buffer
+	for _, req := range []*Command{NewCommand(Commit, nil), NewCommand(Get, []string{"A"})} {
+		_ = req.Send(&pipeline)
+	}
+	if _, err := draining.Write(pipeline.Bytes()); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	if resp, err := ParseCommand(draining); err != nil || resp.Type != None {
+		t.Errorf("Expect the commit done, got %v (err=%v)\n", resp, err)
+	}
+	if resp, err := ParseCommand(draining); err != nil || resp.Type != Error || ToError(resp).Code() != errs.ServerBusy {
+		t.Errorf("Expect %v, got %v (err=%v)\n", errs.ServerBusy, resp, err)
+	}
+	if _, err := ParseCommand(draining); err != io.EOF {
+		t.Errorf("Expect %v, got %v\n", io.EOF, err)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Expect nil, got %v\n", err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("Expect waiting for the stuck txn until the deadline, got %v\n", elapsed)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Expect nil, got %v\n", err)
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Errorf("Expect the listener closed\n")
+	}
+
+	txn := server.Engine.NewTxn()
+	if val, found, _ := server.Engine.Get(txn, "A"); !found || val != "2" {
+		t.Errorf("Expect the drained txn committed, got %v (found=%v)\n", val, found)
+	}
+	if _, found, _ := server.Engine.Get(txn, "B"); found {
+		t.Errorf("Expect the stuck txn aborted\n")
+	}
+}
+
+func TestServer_PipelineBlocked(t *testing.T) {
+	server, addr, _ := startServer(t)
+	defer func() { _ = server.Close() }()
+
+	c1 := dialServer(t, addr)
+	request(t, c1, NewCommand(Begin, nil))
+	request(t, c1, NewCommand(Put, []string{"A", "1"}))
+
+	c2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	request(t, c2, NewHandshake("test", FeaturePipelining).Command())
+
+	// the second request waits for the lock of c1, but the first is answered
+	var pipeline bytes.Buffer
+	for i, key := range []string{"B", "A"} {
+		req := NewCommand(Put, []string{key, "2"})
+		req.ID = uint64(i + 1)
+		if err = req.Send(&pipeline); err != nil {
+			t.Fatalf("Expect nil, got %v\n", err)
+		}
+	}
+	if _, err = c2.Write(pipeline.Bytes()); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+
+	_ = c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := ParseCommand(c2)
+	if err != nil || resp.Type == Error || resp.ID != 1 {
+		t.Fatalf("Expect reply of request 1, got %v (err=%v)\n", resp, err)
+	}
+
+	request(t, c1, NewCommand(Abort, nil))
+	resp, err = ParseCommand(c2)
+	if err != nil || resp.Type == Error || resp.ID != 2 {
+		t.Fatalf("Expect reply of request 2, got %v (err=%v)\n", resp, err)
+	}
+}
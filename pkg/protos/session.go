@@ -3,17 +3,38 @@ package protos
 import "simple-kv/pkg/txns"
 
 type Session struct {
-	txn *txns.Txn
+	txn     *txns.Txn
+	cursors map[string]*Cursor
 }
 
 func NewSession() *Session {
-	return &Session{}
+	return &Session{
+		cursors: map[string]*Cursor{},
+	}
 }
 
 func (s *Session) GetTxn() *txns.Txn {
 	return s.txn
 }
 
+// SetTxn also drops the cursors, since they are bound to the snapshot of the previous transaction
 func (s *Session) SetTxn(txn *txns.Txn) {
 	s.txn = txn
+	s.cursors = map[string]*Cursor{}
+}
+
+func (s *Session) SaveCursor(cursor *Cursor) string {
+	if cursor.Token == "" {
+		cursor.Token = newToken()
+	}
+	s.cursors[cursor.Token] = cursor
+	return cursor.Token
+}
+
+func (s *Session) GetCursor(token string) *Cursor {
+	return s.cursors[token]
+}
+
+func (s *Session) DelCursor(token string) {
+	delete(s.cursors, token)
 }
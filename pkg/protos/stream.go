@@ -0,0 +1,77 @@
+package protos
+
+import (
+	"io"
+	"simple-kv/pkg/config"
+)
+
+/*
+A streaming response is a sequence of frames on the connection:
+
+<stream> := <Chunk>* <End>
+		  | <Chunk>* <Error>
+
+Every Chunk carries at most `size` records and fits in config.MaxFrameSize, so
+that neither side needs to hold the whole result in one frame. An Error frame aborts the stream after the chunks
+already sent, and no other frame can appear before the stream is closed.
+*/
+
+// StreamWriter buffers records and sends them as Chunk frames
+type StreamWriter struct {
+	conn   io.Writer
+	id     uint64
+	size   int
+	buffer []string
+	// length is the payload length of the buffered records
+	length uint64
+}
+
+func NewStreamWriter(conn io.Writer, id uint64, size int) *StreamWriter {
+	return &StreamWriter{
+		conn:   conn,
+		id:     id,
+		size:   size,
+		buffer: make([]string, 0, 2*size),
+	}
+}
+
+func (w *StreamWriter) Write(key string, val string) error {
+	// a record fits in a frame alone, since it has been written by a request
+	length := calcPayloadLength([]string{key, val})
+	if len(w.buffer) > 0 && w.length+length > config.MaxFrameSize {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	w.buffer = append(w.buffer, key, val)
+	w.length += length
+	if len(w.buffer) >= 2*w.size {
+		return w.Flush()
+	}
+	return nil
+}
+
+func (w *StreamWriter) Flush() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+
+	chunk := NewCommand(Chunk, w.buffer)
+	chunk.ID = w.id
+	err := chunk.Send(w.conn)
+	w.buffer = w.buffer[:0]
+	w.length = 0
+	return err
+}
+
+// End flushes the buffered records and returns the End frame, which is sent as
+// the response of the command
+func (w *StreamWriter) End(cursor string) (*Command, error) {
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	end := NewCommand(End, []string{cursor})
+	end.ID = w.id
+	return end, nil
+}
@@ -0,0 +1,42 @@
+package protos
+
+import (
+	"bytes"
+	"simple-kv/pkg/config"
+	"strings"
+	"testing"
+)
+
+func TestStreamWriter_LargeValues(t *testing.T) {
+	maxFrameSize := config.MaxFrameSize
+	config.MaxFrameSize = 1 << 20
+	defer func() { config.MaxFrameSize = maxFrameSize }()
+
+	// every value takes almost a whole frame, far below the count of a chunk
+	val := strings.Repeat("v", int(config.MaxFrameSize)-64)
+	buffer := &bytes.Buffer{}
+	writer := NewStreamWriter(buffer, 1, 100)
+	for _, key := range []string{"A", "B", "C"} {
+		if err := writer.Write(key, val); err != nil {
+			t.Fatalf("Expect nil, got %v\n", err)
+		}
+	}
+	if _, err := writer.End(""); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+
+	var keys []string
+	for buffer.Len() > 0 {
+		chunk, err := ParseCommand(buffer)
+		if err != nil {
+			t.Fatalf("Expect nil, got %v\n", err)
+		}
+		if chunk.Type != Chunk || len(chunk.Payload) != 2 || chunk.Payload[1] != val {
+			t.Fatalf("Expect a chunk of one record, got %v with %d strings\n", chunk.Type, len(chunk.Payload))
+		}
+		keys = append(keys, chunk.Payload[0])
+	}
+	if strings.Join(keys, "") != "ABC" {
+		t.Errorf("Expect A, B and C, got %v\n", keys)
+	}
+}
@@ -1,9 +1,11 @@
 package manager
 
 import (
-	"fmt"
+	"simple-kv/pkg/config"
+	"simple-kv/pkg/errs"
 	modules2 "simple-kv/pkg/modules"
 	"simple-kv/pkg/txns"
+	"simple-kv/pkg/wal"
 	"sync"
 	"sync/atomic"
 )
@@ -13,7 +15,10 @@ type TxnManager struct {
 	ActiveTxns   map[uint64]*txns.Txn
 	ValueManager modules2.ValueManager
 	GC           modules2.GarbageCollector
+	WAL          modules2.WAL
 	latch        sync.Mutex
+	// commitLatch is held shared by every commit in flight, see Freeze
+	commitLatch sync.RWMutex
 }
 
 func NewTxnManager(valueManager modules2.ValueManager) *TxnManager {
@@ -22,7 +27,9 @@ func NewTxnManager(valueManager modules2.ValueManager) *TxnManager {
 		ActiveTxns:   map[uint64]*txns.Txn{},
 		ValueManager: valueManager,
 		GC:           nil,
+		WAL:          nil,
 		latch:        sync.Mutex{},
+		commitLatch:  sync.RWMutex{},
 	}
 }
 
@@ -30,15 +37,21 @@ func (manager *TxnManager) SetGC(gc modules2.GarbageCollector) {
 	manager.GC = gc
 }
 
+// SetWAL makes Commit durable: the WriteSet is logged before the commit returns
+func (manager *TxnManager) SetWAL(wal modules2.WAL) {
+	manager.WAL = wal
+}
+
 func (manager *TxnManager) NewTxn() *txns.Txn {
 	txn := &txns.Txn{
-		ID:       atomic.AddUint64(&manager.TxnCounter, 1),
-		State:    txns.Processing,
-		Waiting:  false,
-		ReadSet:  map[uint64]struct{}{},
-		WriteSet: map[uint64]*txns.WriteInfo{},
-		Latch:    sync.Mutex{},
-		Op:       manager,
+		ID:          atomic.AddUint64(&manager.TxnCounter, 1),
+		State:       txns.Processing,
+		Waiting:     false,
+		LockTimeout: config.LockTimeout,
+		ReadSet:     map[uint64]struct{}{},
+		WriteSet:    map[uint64]*txns.WriteInfo{},
+		Latch:       sync.Mutex{},
+		Op:          manager,
 	}
 	manager.latch.Lock()
 	manager.ActiveTxns[txn.ID] = txn
@@ -63,10 +76,19 @@ func (manager *TxnManager) GetActiveTxns() (res []*txns.Txn) {
 
 func (manager *TxnManager) Commit(txn *txns.Txn) error {
 	if txn.State != txns.Processing {
-		return fmt.Errorf("fail to commit: state=%v", txn.State)
+		return errs.New(errs.TxnNotActive, "fail to commit: state=%v", txn.State)
 	}
 
+	manager.commitLatch.RLock()
+	defer manager.commitLatch.RUnlock()
+
 	txn.CommitID = atomic.AddUint64(&manager.TxnCounter, 1)
+	if err := manager.log(txn); err != nil {
+		// the txn is not durable, so roll it back rather than keep its locks
+		// with nobody left to release them
+		manager.rollback(txn)
+		return errs.New(errs.Internal, "fail to write log, txn aborted: err=%v", err)
+	}
 
 	for valID := range txn.ReadSet {
 		val := manager.ValueManager.GetValue(valID)
@@ -87,10 +109,44 @@ func (manager *TxnManager) Commit(txn *txns.Txn) error {
 	return nil
 }
 
+// Freeze runs `fn` while no commit is in flight, so every transaction committed
+// before has been logged and installed, and every one committed after gets a
+// CommitID above the TxnCounter seen by `fn`
+func (manager *TxnManager) Freeze(fn func()) {
+	manager.commitLatch.Lock()
+	defer manager.commitLatch.Unlock()
+	fn()
+}
+
+// log writes the after-images of the WriteSet, which are still the version
+// headers since the write locks are held until the commit finishes
+func (manager *TxnManager) log(txn *txns.Txn) error {
+	if manager.WAL == nil || len(txn.WriteSet) == 0 {
+		return nil
+	}
+
+	record := &wal.Record{CommitID: txn.CommitID}
+	for valID, info := range txn.WriteSet {
+		header := manager.ValueManager.GetValue(valID).VersionHeader
+		record.Entries = append(record.Entries, &wal.Entry{
+			Key:     info.Key,
+			Val:     header.Val,
+			Deleted: header.Deleted,
+		})
+	}
+	return manager.WAL.Append(record)
+}
+
 func (manager *TxnManager) Abort(txn *txns.Txn) error {
 	if txn.State != txns.Processing {
-		return fmt.Errorf("fail to commit: state=%v", txn.State)
+		return errs.New(errs.TxnNotActive, "fail to abort: state=%v", txn.State)
 	}
+	manager.rollback(txn)
+	return nil
+}
+
+// rollback undoes the versions of the txn and releases its locks
+func (manager *TxnManager) rollback(txn *txns.Txn) {
 	manager.latch.Lock()
 	delete(manager.ActiveTxns, txn.ID)
 	manager.latch.Unlock()
@@ -107,5 +163,4 @@ func (manager *TxnManager) Abort(txn *txns.Txn) error {
 	}
 
 	txn.State = txns.Aborted
-	return nil
 }
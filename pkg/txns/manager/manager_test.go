@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"errors"
+	"simple-kv/pkg/errs"
+	"simple-kv/pkg/gc"
+	lockmanager "simple-kv/pkg/locks/manager"
+	"simple-kv/pkg/txns"
+	valuemanager "simple-kv/pkg/values/manager"
+	"simple-kv/pkg/wal"
+	"testing"
+	"time"
+)
+
+type failingWAL struct{}
+
+func (w *failingWAL) Append(record *wal.Record) error {
+	return errors.New("disk full")
+}
+
+func TestTxnManager_CommitLogError(t *testing.T) {
+	valueManager := valuemanager.NewValueManager()
+	lockManager := lockmanager.NewLockManager()
+	manager := NewTxnManager(valueManager)
+	manager.SetGC(gc.NewGarbageCollector(manager, valueManager))
+	manager.SetWAL(&failingWAL{})
+
+	val := valueManager.NewValue(lockManager.NewRWLock("A"))
+	txn1 := manager.NewTxn()
+	if _, err := val.Put(txn1, "1"); err != nil {
+		t.Fatalf("Expect nil, got %v\n", err)
+	}
+	txn1.SetWriting(val.ID, "A", 0)
+
+	if err := txn1.Commit(); !errs.Is(err, errs.Internal) {
+		t.Errorf("Expect %v, got %v\n", errs.Internal, err)
+	}
+	if txn1.State != txns.Aborted {
+		t.Errorf("Expect %v, got %v\n", txns.Aborted, txn1.State)
+	}
+	if active := manager.GetActiveTxns(); len(active) != 0 {
+		t.Errorf("Expect no active txn, got %d\n", len(active))
+	}
+
+	// the write lock of the failed txn is released
+	txn2 := manager.NewTxn()
+	done := make(chan error, 1)
+	go func() {
+		_, err := val.Put(txn2, "2")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expect nil, got %v\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Expect the lock released\n")
+	}
+	if val.VersionHeader == nil || val.VersionHeader.Val != "2" || val.VersionHeader.Next != nil {
+		t.Errorf("Expect only the version of txn2, got %v\n", val.VersionHeader)
+	}
+}
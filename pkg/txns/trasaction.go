@@ -1,6 +1,11 @@
 package txns
 
-import "sync"
+import (
+	"simple-kv/pkg/errs"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 type State int
 
@@ -10,12 +15,15 @@ const (
 	Aborted
 )
 
+// NoWait is the LockTimeout to fail a lock at once if it would block
+const NoWait time.Duration = -1
+
 type WriteInfo struct {
-	Key     uint64
+	Key     string
 	IndexID uint64
 }
 
-func NewWriteInfo(key uint64, index uint64) *WriteInfo {
+func NewWriteInfo(key string, index uint64) *WriteInfo {
 	return &WriteInfo{
 		Key:     key,
 		IndexID: index,
@@ -28,9 +36,15 @@ type Operator interface {
 }
 
 type Txn struct {
-	ID       uint64
-	State    State
-	Waiting  bool
+	ID      uint64
+	State   State
+	Waiting bool
+	// LockTimeout bounds the wait for a lock, 0 means waiting until it is granted
+	LockTimeout time.Duration
+	// Priority is assigned by the client, a deadlock may abort the txn with the lowest one
+	Priority int
+	// Client is the address of the client running the txn, to report deadlocks
+	Client   string
 	CommitID uint64
 	// ReadSet is to release read lock
 	ReadSet map[uint64]struct{}
@@ -38,6 +52,15 @@ type Txn struct {
 	WriteSet map[uint64]*WriteInfo
 	Latch    sync.Mutex
 	Op       Operator
+
+	// locks and writes count the entries of ReadSet and WriteSet, which other
+	// goroutines choosing a deadlock victim read without the txn
+	locks  int32
+	writes int32
+	// wounded is set by an older txn in the wound-wait mode, see locks.RWLock
+	wounded int32
+	// waker wakes up the txn waiting for a lock, guarded by Latch
+	waker func(err error)
 }
 
 func (txn *Txn) IsWriting(valueID uint64) bool {
@@ -50,14 +73,64 @@ func (txn *Txn) IsReading(valueID uint64) bool {
 	return exist
 }
 
-func (txn *Txn) SetWriting(valueID uint64, key uint64, indexID uint64) {
+func (txn *Txn) SetWriting(valueID uint64, key string, indexID uint64) {
+	if !txn.IsWriting(valueID) {
+		atomic.AddInt32(&txn.locks, 1)
+		atomic.AddInt32(&txn.writes, 1)
+	}
 	txn.WriteSet[valueID] = NewWriteInfo(key, indexID)
 }
 
 func (txn *Txn) SetReading(valueID uint64) {
+	if !txn.IsReading(valueID) {
+		atomic.AddInt32(&txn.locks, 1)
+	}
 	txn.ReadSet[valueID] = struct{}{}
 }
 
+// LockCount is the number of read and write locks taken, safe to call from
+// other goroutines
+func (txn *Txn) LockCount() int {
+	return int(atomic.LoadInt32(&txn.locks))
+}
+
+// WriteCount is the size of WriteSet, safe to call from other goroutines
+func (txn *Txn) WriteCount() int {
+	return int(atomic.LoadInt32(&txn.writes))
+}
+
+// Wound asks the txn to abort itself since an older txn waits for it, the txn
+// is woken up if it is waiting for a lock
+func (txn *Txn) Wound() {
+	if !atomic.CompareAndSwapInt32(&txn.wounded, 0, 1) {
+		return
+	}
+	txn.Wake(errs.New(errs.DeadlockVictim, "txn wounded by an older one: txn=%d", txn.ID))
+}
+
+func (txn *Txn) Wounded() bool {
+	return atomic.LoadInt32(&txn.wounded) == 1
+}
+
+// Wake fails the lock wait of the txn with `err`, it tells whether the txn is waiting
+func (txn *Txn) Wake(err error) bool {
+	txn.Latch.Lock()
+	waker := txn.waker
+	txn.Latch.Unlock()
+	if waker == nil {
+		return false
+	}
+	waker(err)
+	return true
+}
+
+// SetWaker sets how to wake up the txn while it waits for a lock, or nil once it stops waiting
+func (txn *Txn) SetWaker(waker func(err error)) {
+	txn.Latch.Lock()
+	txn.waker = waker
+	txn.Latch.Unlock()
+}
+
 func (txn *Txn) Commit() error {
 	return txn.Op.Commit(txn)
 }
@@ -66,11 +66,28 @@ func (v *Value) Traverse(txn *txns.Txn) (*Version, error) {
 	return nil, nil
 }
 
+// Snapshot reads the version visible at timestamp `ts` without taking any lock,
+// the caller should keep a transaction with ID <= `ts` active against GC
+func (v *Value) Snapshot(ts uint64) *Version {
+	v.Latch.Lock()
+	defer v.Latch.Unlock()
+
+	for version := v.VersionHeader; version != nil; version = version.Next {
+		if version.IsVisible(ts) {
+			if version.Deleted {
+				return nil
+			}
+			return version
+		}
+	}
+	return nil
+}
+
 func (v *Value) Put(txn *txns.Txn, val string) (bool, error) {
 	v.Latch.Lock()
 	defer v.Latch.Unlock()
 
-	if v.HeaderLock.WritingTxnID != txn.ID {
+	if v.HeaderLock.GetWritingTxnID() != txn.ID {
 		v.Latch.Unlock()
 		err := v.HeaderLock.Lock(txn)
 		v.Latch.Lock()
@@ -0,0 +1,291 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"simple-kv/pkg/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	SegmentSuffix = ".wal"
+	// LegacyFileName is the single log file before the log was split into segments
+	LegacyFileName = "wal.log"
+)
+
+// ErrUnsupportedVersion is returned for a log written in another format version
+var ErrUnsupportedVersion = errors.New("unsupported log version")
+
+// Log is a sequence of segment files, and records are appended to the last one
+type Log struct {
+	Dir   string
+	Seq   uint64
+	file  *os.File
+	latch sync.Mutex
+}
+
+func SegmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", seq, SegmentSuffix))
+}
+
+// Segments lists the sequence numbers of segments in `dir` in ascending order
+func Segments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var seqs []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, SegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, SegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+// Open opens the last segment in `dir` for appending, and creates one if not exists
+func Open(dir string) (*Log, error) {
+	seqs, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	seq := uint64(1)
+	if len(seqs) != 0 {
+		seq = seqs[len(seqs)-1]
+	}
+
+	file, err := openSegment(SegmentPath(dir, seq))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{
+		Dir:   dir,
+		Seq:   seq,
+		file:  file,
+		latch: sync.Mutex{},
+	}, nil
+}
+
+func openSegment(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	if stat.Size() == 0 {
+		err = writeFileHeader(file)
+	} else {
+		err = checkFileHeader(file)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Append writes the record and fsync before returning
+func (l *Log) Append(record *Record) error {
+	buffer := record.Serialize()
+
+	l.latch.Lock()
+	defer l.latch.Unlock()
+
+	if _, err := l.file.Write(buffer); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// Rotate switches appending to a new segment, and returns its sequence number
+func (l *Log) Rotate() (uint64, error) {
+	l.latch.Lock()
+	defer l.latch.Unlock()
+
+	file, err := openSegment(SegmentPath(l.Dir, l.Seq+1))
+	if err != nil {
+		return 0, err
+	}
+
+	_ = l.file.Close()
+	l.file = file
+	l.Seq++
+	return l.Seq, nil
+}
+
+// RemoveBefore deletes the segments whose sequence number < `seq`
+func (l *Log) RemoveBefore(seq uint64) error {
+	seqs, err := Segments(l.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range seqs {
+		if s >= seq {
+			break
+		}
+		if err = os.Remove(SegmentPath(l.Dir, s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.latch.Lock()
+	defer l.latch.Unlock()
+	return l.file.Close()
+}
+
+func writeFileHeader(file *os.File) error {
+	header := make([]byte, FileHeaderLength)
+	binary.BigEndian.PutUint32(header, Magic)
+	binary.BigEndian.PutUint16(header[4:], Version)
+	if _, err := file.Write(header); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func checkFileHeader(reader io.Reader) error {
+	header := make([]byte, FileHeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return fmt.Errorf("fail to read log header: err=%v", err)
+	}
+	if magic := binary.BigEndian.Uint32(header); magic != Magic {
+		return fmt.Errorf("invalid log magic: magic=%x", magic)
+	}
+	if version := binary.BigEndian.Uint16(header[4:]); version != Version {
+		return fmt.Errorf("%w: expect=%d, got=%d", ErrUnsupportedVersion, Version, version)
+	}
+	return nil
+}
+
+// ReadLog reads all intact records of the log at `path`. It stops at the first
+// torn or corrupted record, and returns the offset right after the last intact one.
+func ReadLog(path string) (records []*Record, end int64, err error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the file header itself is torn
+	if stat.Size() < FileHeaderLength {
+		return nil, 0, nil
+	}
+
+	reader := bufio.NewReader(file)
+	if err = checkFileHeader(reader); err != nil {
+		return nil, 0, err
+	}
+
+	end = FileHeaderLength
+	header := make([]byte, RecordHeaderLength)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+
+		length := int64(binary.BigEndian.Uint32(header))
+		if end+RecordHeaderLength+length > stat.Size() {
+			break
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			break
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+
+		record, err := parseRecord(payload)
+		if err != nil {
+			break
+		}
+		records = append(records, record)
+		end += int64(RecordHeaderLength + len(payload))
+	}
+	return records, end, nil
+}
+
+// Recover reads all intact records of the log at `path`, and truncates the torn
+// tail left by a crash so that new records can be appended after the intact ones.
+func Recover(path string) ([]*Record, error) {
+	records, end, err := ReadLog(path)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stat.Size() > end {
+		logger.Inst.Warnw("truncate torn log tail",
+			"path", path,
+			"size", stat.Size(),
+			"end", end)
+		if err = os.Truncate(path, end); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// RecoverAll recovers every segment in `dir`, and returns their records in log order
+func RecoverAll(dir string) ([]*Record, error) {
+	seqs, err := Segments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, seq := range seqs {
+		path := SegmentPath(dir, seq)
+		res, err := Recover(path)
+		if err != nil {
+			return nil, fmt.Errorf("fail to recover %s: %w", path, err)
+		}
+		records = append(records, res...)
+	}
+	return records, nil
+}
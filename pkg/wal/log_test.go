@@ -0,0 +1,139 @@
+package wal
+
+import (
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestLog_Append(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := SegmentPath(dir, log.Seq)
+
+	for i := uint64(1); i <= 100; i++ {
+		err = log.Append(&Record{
+			CommitID: i,
+			Entries: []*Entry{
+				{Key: strconv.FormatUint(i, 10), Val: "val", Deleted: false},
+				{Key: strconv.FormatUint(i+1000, 10), Val: "", Deleted: true},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = log.Close()
+
+	records, end, err := ReadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 100 {
+		t.Fatalf("Expect 100, got %d\n", len(records))
+	}
+	if stat, _ := os.Stat(path); stat.Size() != end {
+		t.Errorf("Expect %d, got %d\n", stat.Size(), end)
+	}
+
+	record := records[41]
+	if record.CommitID != 42 || len(record.Entries) != 2 {
+		t.Fatalf("Expect record 42 with 2 entries, got %v\n", record)
+	}
+	if e := record.Entries[0]; e.Key != "42" || e.Val != "val" || e.Deleted {
+		t.Errorf("Expect {42 val false}, got %v\n", e)
+	}
+	if e := record.Entries[1]; e.Key != "1042" || !e.Deleted {
+		t.Errorf("Expect {1042 deleted}, got %v\n", e)
+	}
+}
+
+func TestLog_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := SegmentPath(dir, log.Seq)
+	for i := uint64(1); i <= 3; i++ {
+		_ = log.Append(&Record{CommitID: i, Entries: []*Entry{{Key: strconv.FormatUint(i, 10), Val: "val"}}})
+	}
+	_ = log.Close()
+
+	_, intact, _ := ReadLog(path)
+	stat, _ := os.Stat(path)
+	_ = os.Truncate(path, stat.Size()-3)
+
+	records, end, err := ReadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Errorf("Expect 2, got %d\n", len(records))
+	}
+	if end >= intact {
+		t.Errorf("Expect end < %d, got %d\n", intact, end)
+	}
+
+	// flip one byte of the last intact record
+	file, _ := os.OpenFile(path, os.O_RDWR, 0644)
+	_, _ = file.WriteAt([]byte{0xff}, end-1)
+	_ = file.Close()
+
+	records, _, err = ReadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("Expect 1, got %d\n", len(records))
+	}
+}
+
+func TestLog_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	log, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := uint64(1); i <= 6; i++ {
+		_ = log.Append(&Record{CommitID: i, Entries: []*Entry{{Key: strconv.FormatUint(i, 10), Val: "val"}}})
+		if i%2 == 0 {
+			if _, err = log.Rotate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	_ = log.Close()
+
+	records, err := RecoverAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 6 {
+		t.Fatalf("Expect 6, got %d\n", len(records))
+	}
+	for i, record := range records {
+		if record.CommitID != uint64(i+1) {
+			t.Errorf("Expect %d, got %d\n", i+1, record.CommitID)
+		}
+	}
+
+	log, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+	if log.Seq != 4 {
+		t.Errorf("Expect 4, got %d\n", log.Seq)
+	}
+
+	_ = log.RemoveBefore(3)
+	seqs, _ := Segments(dir)
+	if len(seqs) != 2 || seqs[0] != 3 {
+		t.Errorf("Expect [3 4], got %v\n", seqs)
+	}
+}
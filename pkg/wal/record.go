@@ -0,0 +1,106 @@
+package wal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	Magic   = uint32(0x534b5657) // "SKVW"
+	Version = uint16(2)
+
+	FileHeaderLength   = 6
+	RecordHeaderLength = 8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Entry is the after-image of one key written by a committed transaction
+type Entry struct {
+	Key     string
+	Val     string
+	Deleted bool
+}
+
+// Record is the value logging of a committed transaction's WriteSet
+type Record struct {
+	CommitID uint64
+	Entries  []*Entry
+}
+
+/*
+<file>    := <magic:4> <version:2> <record>*
+<record>  := <length:4> <crc32c:4> <payload>
+<payload> := <commitID:8> <count:4> <entry>*
+<entry>   := <flags:1> <length:4> <key> <length:4> <value>
+*/
+
+func (r *Record) payloadLength() int {
+	length := 8 + 4
+	for _, e := range r.Entries {
+		length += 1 + 4 + len(e.Key) + 4 + len(e.Val)
+	}
+	return length
+}
+
+func (r *Record) Serialize() []byte {
+	buffer := make([]byte, RecordHeaderLength+r.payloadLength())
+	payload := buffer[RecordHeaderLength:]
+	binary.BigEndian.PutUint64(payload, r.CommitID)
+	binary.BigEndian.PutUint32(payload[8:], uint32(len(r.Entries)))
+
+	i := 12
+	for _, e := range r.Entries {
+		if e.Deleted {
+			payload[i] = 1
+		}
+		i++
+		for _, field := range []string{e.Key, e.Val} {
+			binary.BigEndian.PutUint32(payload[i:], uint32(len(field)))
+			copy(payload[i+4:], field)
+			i += 4 + len(field)
+		}
+	}
+
+	binary.BigEndian.PutUint32(buffer, uint32(len(payload)))
+	binary.BigEndian.PutUint32(buffer[4:], crc32.Checksum(payload, crcTable))
+	return buffer
+}
+
+func parseRecord(payload []byte) (*Record, error) {
+	if len(payload) < 12 {
+		return nil, fmt.Errorf("record too short: length=%d", len(payload))
+	}
+
+	record := &Record{CommitID: binary.BigEndian.Uint64(payload)}
+	count := binary.BigEndian.Uint32(payload[8:])
+	i := 12
+	for ; count > 0; count-- {
+		if i+1 > len(payload) {
+			return nil, fmt.Errorf("entry header out of range: offset=%d", i)
+		}
+		entry := &Entry{Deleted: payload[i]&1 != 0}
+		i++
+
+		var fields [2]string
+		for j := range fields {
+			if i+4 > len(payload) {
+				return nil, fmt.Errorf("entry length out of range: offset=%d", i)
+			}
+			l := int(binary.BigEndian.Uint32(payload[i:]))
+			if i+4+l > len(payload) {
+				return nil, fmt.Errorf("entry field out of range: offset=%d, length=%d", i, l)
+			}
+			fields[j] = string(payload[i+4 : i+4+l])
+			i += 4 + l
+		}
+		entry.Key, entry.Val = fields[0], fields[1]
+		record.Entries = append(record.Entries, entry)
+	}
+
+	if i != len(payload) {
+		return nil, fmt.Errorf("record has trailing bytes: expect=%d, got=%d", i, len(payload))
+	}
+	return record, nil
+}
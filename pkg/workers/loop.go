@@ -0,0 +1,61 @@
+package workers
+
+import (
+	"sync"
+	"time"
+)
+
+// Loop runs a background worker periodically until it is stopped
+type Loop struct {
+	latch   sync.Mutex
+	running bool
+	stopped bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewLoop() *Loop {
+	return &Loop{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Run calls `fn` every `interval` until Stop is called, it returns at once if
+// the loop is running or has been stopped
+func (l *Loop) Run(interval time.Duration, fn func()) {
+	l.latch.Lock()
+	if l.running || l.stopped {
+		l.latch.Unlock()
+		return
+	}
+	l.running = true
+	l.latch.Unlock()
+	defer close(l.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fn()
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// Stop stops the loop and waits for the running turn to finish
+func (l *Loop) Stop() {
+	l.latch.Lock()
+	if !l.stopped {
+		l.stopped = true
+		close(l.stop)
+	}
+	running := l.running
+	l.latch.Unlock()
+
+	if running {
+		<-l.done
+	}
+}